@@ -187,6 +187,16 @@ func yaml_emitter_set_break(emitter *yaml_emitter_t, line_break yaml_break_t) {
 	emitter.line_break = line_break
 }
 
+// [Go] Set if a BOM is written with the UTF-8 encoding.
+func yaml_emitter_set_utf8_bom(emitter *yaml_emitter_t, bom bool) {
+	emitter.utf8_bom = bom
+}
+
+// [Go] Set if block sequences which are mapping values are left indentless.
+func yaml_emitter_set_indentless_sequences(emitter *yaml_emitter_t, indentless bool) {
+	emitter.indentless_sequences = indentless
+}
+
 ///*
 // * Destroy a token object.
 // */
@@ -18,6 +18,7 @@ package yaml
 import (
 	"encoding"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -202,11 +203,29 @@ func (p *parser) parseChild(parent *Node) *Node {
 func (p *parser) document() *Node {
 	n := p.node(DocumentNode, "", "", "")
 	p.doc = n
+	if v, tags := p.event.version_directive, p.event.tag_directives; v != nil || len(tags) > 0 {
+		n.Document = &DocumentInfo{}
+		if v != nil {
+			n.Document.Version = fmt.Sprintf("%d.%d", v.major, v.minor)
+		}
+		for _, tag := range tags {
+			n.Document.TagDirectives = append(n.Document.TagDirectives, TagDirective{
+				Handle: string(tag.handle),
+				Prefix: string(tag.prefix),
+			})
+		}
+	}
 	p.expect(yaml_DOCUMENT_START_EVENT)
 	p.parseChild(n)
 	if p.peek() == yaml_DOCUMENT_END_EVENT {
 		n.FootComment = string(p.event.foot_comment)
 	}
+	if b := lineBreakOf(p.parser.line_break); b != 0 {
+		if n.Document == nil {
+			n.Document = &DocumentInfo{}
+		}
+		n.Document.LineBreak = b
+	}
 	p.expect(yaml_DOCUMENT_END_EVENT)
 	return n
 }
@@ -318,13 +337,28 @@ type decoder struct {
 	stringMapType  reflect.Type
 	generalMapType reflect.Type
 
-	knownFields bool
-	uniqueKeys  bool
-	decodeCount int
-	aliasCount  int
-	aliasDepth  int
+	structOpts structOptions
+
+	knownFields     bool
+	uniqueKeys      bool
+	caseInsensitive bool
+	orderedMaps     bool
+	strict          bool
+	strictStrings   bool
+	allowedTags     map[string]bool
+	decodeCount     int
+	aliasCount      int
+	aliasDepth      int
 
 	mergedFields map[interface{}]bool
+
+	// defaulting holds the fields whose default value is being decoded.
+	defaulting map[*fieldInfo]bool
+
+	// fieldPath holds the mapping keys and sequence indexes leading to
+	// the value being decoded, used to locate missing ,required fields
+	// in error messages.
+	fieldPath []pathElem
 }
 
 var (
@@ -333,6 +367,8 @@ var (
 	stringMapType  = reflect.TypeOf(map[string]interface{}{})
 	generalMapType = reflect.TypeOf(map[interface{}]interface{}{})
 	ifaceType      = generalMapType.Elem()
+	mapSliceType   = reflect.TypeOf(MapSlice{})
+	mapItemType    = reflect.TypeOf(MapItem{})
 	timeType       = reflect.TypeOf(time.Time{})
 	ptrTimeType    = reflect.TypeOf(&time.Time{})
 )
@@ -347,6 +383,9 @@ func newDecoder() *decoder {
 	return d
 }
 
+// strictTags holds the tags accepted in strict mode by default.
+var strictTags = []string{nullTag, boolTag, intTag, floatTag, strTag, seqTag, mapTag}
+
 func (d *decoder) terror(n *Node, tag string, out reflect.Value) {
 	if n.Tag != "" {
 		tag = n.Tag
@@ -493,6 +532,9 @@ func (d *decoder) unmarshal(n *Node, out reflect.Value) (good bool) {
 		out.Set(reflect.ValueOf(n).Elem())
 		return true
 	}
+	if d.strict && !d.strictNode(n) {
+		return false
+	}
 	switch n.Kind {
 	case DocumentNode:
 		return d.document(n, out)
@@ -521,6 +563,39 @@ func (d *decoder) unmarshal(n *Node, out reflect.Value) (good bool) {
 	return good
 }
 
+// strictNode reports the constructs in n that are rejected in strict
+// mode, and returns whether n may still be decoded.
+func (d *decoder) strictNode(n *Node) bool {
+	if n.Kind == AliasNode {
+		d.terrors = append(d.terrors, fmt.Sprintf("line %d: alias *%s is not allowed in strict mode", n.Line, n.Value))
+		return false
+	}
+	if n.Style&TaggedStyle != 0 && !d.allowedTags[n.ShortTag()] {
+		d.terrors = append(d.terrors, fmt.Sprintf("line %d: tag %s is not allowed in strict mode", n.Line, n.ShortTag()))
+		return false
+	}
+	good := true
+	if n.Kind == MappingNode {
+		// Mappings with rejected keys are not decoded, so the keys
+		// aren't reported again when they fail to decode.
+		for i := 0; i < len(n.Content); i += 2 {
+			k := n.Content[i]
+			switch {
+			case isMerge(k):
+				d.terrors = append(d.terrors, fmt.Sprintf("line %d: merge key is not allowed in strict mode", k.Line))
+				good = false
+			case k.Kind == ScalarNode && k.ShortTag() != strTag:
+				d.terrors = append(d.terrors, fmt.Sprintf("line %d: non-string key %s `%s` is not allowed in strict mode", k.Line, k.ShortTag(), k.Value))
+				good = false
+			case k.Kind == MappingNode || k.Kind == SequenceNode:
+				d.terrors = append(d.terrors, fmt.Sprintf("line %d: non-string key %s is not allowed in strict mode", k.Line, k.ShortTag()))
+				good = false
+			}
+		}
+	}
+	return good
+}
+
 func (d *decoder) document(n *Node, out reflect.Value) (good bool) {
 	if len(n.Content) == 1 {
 		d.doc = n
@@ -613,6 +688,10 @@ func (d *decoder) scalar(n *Node, out reflect.Value) bool {
 			out.SetString(resolved.(string))
 			return true
 		}
+		if d.strictStrings && tag != strTag {
+			d.terrors = append(d.terrors, fmt.Sprintf("line %d: cannot unmarshal %s `%s` into %s", n.Line, shortTag(tag), n.Value, out.Type()))
+			return false
+		}
 		out.SetString(n.Value)
 		return true
 	case reflect.Interface:
@@ -719,6 +798,19 @@ func (d *decoder) scalar(n *Node, out reflect.Value) bool {
 	return false
 }
 
+// unquoted returns a copy of the string scalar n without its tag and
+// quoting, so that values of fields with the json ,string option
+// resolve into their own types.
+func unquoted(n *Node) *Node {
+	if n.Kind != ScalarNode || !n.indicatedString() {
+		return n
+	}
+	u := *n
+	u.Tag = ""
+	u.Style = 0
+	return &u
+}
+
 func settableValueOf(i interface{}) reflect.Value {
 	v := reflect.ValueOf(i)
 	sv := reflect.New(v.Type()).Elem()
@@ -750,7 +842,10 @@ func (d *decoder) sequence(n *Node, out reflect.Value) (good bool) {
 	j := 0
 	for i := 0; i < l; i++ {
 		e := reflect.New(et).Elem()
-		if ok := d.unmarshal(n.Content[i], e); ok {
+		d.fieldPath = append(d.fieldPath, pathElem{index: i})
+		ok := d.unmarshal(n.Content[i], e)
+		d.fieldPath = d.fieldPath[:len(d.fieldPath)-1]
+		if ok {
 			out.Index(j).Set(e)
 			j++
 		}
@@ -784,9 +879,17 @@ func (d *decoder) mapping(n *Node, out reflect.Value) (good bool) {
 	switch out.Kind() {
 	case reflect.Struct:
 		return d.mappingStruct(n, out)
+	case reflect.Slice:
+		return d.mappingSlice(n, out)
 	case reflect.Map:
 		// okay
 	case reflect.Interface:
+		if d.orderedMaps {
+			slicev := reflect.New(mapSliceType).Elem()
+			good = d.mappingSlice(n, slicev)
+			out.Set(slicev)
+			return good
+		}
 		iface := out
 		if isStringMap(n) {
 			out = reflect.MakeMap(d.stringMapType)
@@ -805,12 +908,14 @@ func (d *decoder) mapping(n *Node, out reflect.Value) (good bool) {
 
 	stringMapType := d.stringMapType
 	generalMapType := d.generalMapType
+	orderedMaps := d.orderedMaps
 	if outt.Elem() == ifaceType {
 		if outt.Key().Kind() == reflect.String {
 			d.stringMapType = outt
 		} else if outt.Key() == ifaceType {
 			d.generalMapType = outt
 		}
+		d.orderedMaps = false
 	}
 
 	mergedFields := d.mergedFields
@@ -823,13 +928,14 @@ func (d *decoder) mapping(n *Node, out reflect.Value) (good bool) {
 		out.Set(reflect.MakeMap(outt))
 		mapIsNew = true
 	}
+	isSet := n.ShortTag() == setTag
 	for i := 0; i < l; i += 2 {
 		if isMerge(n.Content[i]) {
 			mergeNode = n.Content[i+1]
 			continue
 		}
 		k := reflect.New(kt).Elem()
-		if d.unmarshal(n.Content[i], k) {
+		if d.mapKey(n.Content[i], k) {
 			if mergedFields != nil {
 				ki := k.Interface()
 				if mergedFields[ki] {
@@ -844,8 +950,15 @@ func (d *decoder) mapping(n *Node, out reflect.Value) (good bool) {
 			if kkind == reflect.Map || kkind == reflect.Slice {
 				failf("invalid map key: %#v", k.Interface())
 			}
+			if isSet {
+				d.setEntry(n.Content[i+1], out, k)
+				continue
+			}
 			e := reflect.New(et).Elem()
-			if d.unmarshal(n.Content[i+1], e) || n.Content[i+1].ShortTag() == nullTag && (mapIsNew || !out.MapIndex(k).IsValid()) {
+			d.fieldPath = append(d.fieldPath, pathElem{key: k})
+			ok := d.unmarshal(n.Content[i+1], e)
+			d.fieldPath = d.fieldPath[:len(d.fieldPath)-1]
+			if ok || n.Content[i+1].ShortTag() == nullTag && (mapIsNew || !out.MapIndex(k).IsValid()) {
 				out.SetMapIndex(k, e)
 			}
 		}
@@ -858,6 +971,93 @@ func (d *decoder) mapping(n *Node, out reflect.Value) (good bool) {
 
 	d.stringMapType = stringMapType
 	d.generalMapType = generalMapType
+	d.orderedMaps = orderedMaps
+	return true
+}
+
+// setEntry stores k as a member of the set out, given the value node
+// of its !!set entry, which must be null. Members map to true in sets
+// decoded into maps with bool values, and to the zero value otherwise.
+func (d *decoder) setEntry(value *Node, out, k reflect.Value) {
+	if value.ShortTag() != nullTag {
+		d.terrors = append(d.terrors, fmt.Sprintf("line %d: !!set entries must have null values", value.Line))
+		return
+	}
+	e := reflect.New(out.Type().Elem()).Elem()
+	if e.Kind() == reflect.Bool {
+		e.SetBool(true)
+	}
+	out.SetMapIndex(k, e)
+}
+
+// mapKey decodes the mapping key n into k. Keys of types implementing
+// encoding.TextUnmarshaler are decoded through it from any scalar text,
+// including text that resolves to null, matching how keys implementing
+// encoding.TextMarshaler are encoded.
+func (d *decoder) mapKey(n *Node, k reflect.Value) (good bool) {
+	if n.Kind != ScalarNode || n.ShortTag() != nullTag || k.Kind() == reflect.Ptr || k.Kind() == reflect.Interface {
+		return d.unmarshal(n, k)
+	}
+	switch u := k.Addr().Interface().(type) {
+	case Unmarshaler, obsoleteUnmarshaler:
+	case encoding.TextUnmarshaler:
+		if err := u.UnmarshalText([]byte(n.Value)); err != nil {
+			fail(err)
+		}
+		return true
+	}
+	return d.unmarshal(n, k)
+}
+
+func (d *decoder) mappingSlice(n *Node, out reflect.Value) (good bool) {
+	outt := out.Type()
+	if outt.Elem() != mapItemType {
+		d.terror(n, mapTag, out)
+		return false
+	}
+
+	orderedMaps := d.orderedMaps
+	d.orderedMaps = true
+
+	mergedFields := d.mergedFields
+	d.mergedFields = nil
+
+	var mergeNode *Node
+
+	l := len(n.Content)
+	if mergedFields == nil {
+		out.Set(reflect.MakeSlice(outt, 0, l/2))
+	}
+	for i := 0; i < l; i += 2 {
+		if isMerge(n.Content[i]) {
+			mergeNode = n.Content[i+1]
+			continue
+		}
+		var item MapItem
+		k := reflect.ValueOf(&item.Key).Elem()
+		if d.unmarshal(n.Content[i], k) {
+			if kkind := k.Elem().Kind(); kkind == reflect.Map || kkind == reflect.Slice {
+				failf("invalid map key: %#v", k.Interface())
+			}
+			if mergedFields != nil {
+				if mergedFields[item.Key] {
+					continue
+				}
+				mergedFields[item.Key] = true
+			}
+			v := reflect.ValueOf(&item.Value).Elem()
+			if d.unmarshal(n.Content[i+1], v) {
+				out.Set(reflect.Append(out, reflect.ValueOf(item)))
+			}
+		}
+	}
+
+	d.mergedFields = mergedFields
+	if mergeNode != nil {
+		d.merge(n, mergeNode, out)
+	}
+
+	d.orderedMaps = orderedMaps
 	return true
 }
 
@@ -876,7 +1076,7 @@ func isStringMap(n *Node) bool {
 }
 
 func (d *decoder) mappingStruct(n *Node, out reflect.Value) (good bool) {
-	sinfo, err := getStructInfo(out.Type())
+	sinfo, err := getStructInfo(out.Type(), d.structOpts)
 	if err != nil {
 		panic(err)
 	}
@@ -897,9 +1097,13 @@ func (d *decoder) mappingStruct(n *Node, out reflect.Value) (good bool) {
 	d.mergedFields = nil
 	var mergeNode *Node
 	var doneFields []bool
-	if d.uniqueKeys {
+	if d.uniqueKeys || len(sinfo.Required) > 0 || len(sinfo.Defaults) > 0 {
 		doneFields = make([]bool, len(sinfo.FieldsList))
 	}
+	var keyNames []string
+	if d.caseInsensitive {
+		keyNames = make([]string, len(sinfo.FieldsList))
+	}
 	name := settableValueOf("")
 	l := len(n.Content)
 	for i := 0; i < l; i += 2 {
@@ -917,28 +1121,62 @@ func (d *decoder) mappingStruct(n *Node, out reflect.Value) (good bool) {
 				continue
 			}
 			mergedFields[sname] = true
+			if d.caseInsensitive {
+				fkey := foldedKey(foldKey(sname))
+				if mergedFields[fkey] {
+					continue
+				}
+				mergedFields[fkey] = true
+			}
+		}
+		info, ok := sinfo.FieldsMap[sname]
+		if !ok && d.caseInsensitive {
+			// Exact matches are always preferred over folded ones.
+			switch ids := sinfo.FoldedKeys[foldKey(sname)]; len(ids) {
+			case 0:
+			case 1:
+				info, ok = sinfo.FieldsList[ids[0]], true
+			default:
+				d.terrors = append(d.terrors, fmt.Sprintf("line %d: field %s matches multiple fields ignoring case in type %s", ni.Line, sname, out.Type()))
+				continue
+			}
 		}
-		if info, ok := sinfo.FieldsMap[sname]; ok {
-			if d.uniqueKeys {
-				if doneFields[info.Id] {
+		if ok {
+			if d.uniqueKeys && doneFields[info.Id] {
+				if keyNames != nil && keyNames[info.Id] != sname {
+					d.terrors = append(d.terrors, fmt.Sprintf("line %d: field %s conflicts with field %s ignoring case in type %s", ni.Line, sname, keyNames[info.Id], out.Type()))
+				} else {
 					d.terrors = append(d.terrors, fmt.Sprintf("line %d: field %s already set in type %s", ni.Line, name.String(), out.Type()))
-					continue
 				}
+				continue
+			}
+			if doneFields != nil {
 				doneFields[info.Id] = true
 			}
+			if keyNames != nil {
+				keyNames[info.Id] = sname
+			}
 			var field reflect.Value
 			if info.Inline == nil {
 				field = out.Field(info.Num)
 			} else {
 				field = d.fieldByIndex(n, out, info.Inline)
 			}
-			d.unmarshal(n.Content[i+1], field)
+			value := n.Content[i+1]
+			if info.String {
+				value = unquoted(value)
+			}
+			d.fieldPath = append(d.fieldPath, pathElem{key: name})
+			d.unmarshal(value, field)
+			d.fieldPath = d.fieldPath[:len(d.fieldPath)-1]
 		} else if sinfo.InlineMap != -1 {
 			if inlineMap.IsNil() {
 				inlineMap.Set(reflect.MakeMap(inlineMap.Type()))
 			}
 			value := reflect.New(elemType).Elem()
+			d.fieldPath = append(d.fieldPath, pathElem{key: name})
 			d.unmarshal(n.Content[i+1], value)
+			d.fieldPath = d.fieldPath[:len(d.fieldPath)-1]
 			inlineMap.SetMapIndex(name, value)
 		} else if d.knownFields {
 			d.terrors = append(d.terrors, fmt.Sprintf("line %d: field %s not found in type %s", ni.Line, name.String(), out.Type()))
@@ -946,17 +1184,115 @@ func (d *decoder) mappingStruct(n *Node, out reflect.Value) (good bool) {
 	}
 
 	d.mergedFields = mergedFields
-	if mergeNode != nil {
-		d.merge(n, mergeNode, out)
+	var merged map[interface{}]bool
+	if mergeNode != nil && sinfo.MergeField >= 0 {
+		// The merged mappings are held by the ,merge field itself.
+		d.fieldPath = append(d.fieldPath, pathElem{key: reflect.ValueOf("<<")})
+		d.unmarshal(mergeNode, out.Field(sinfo.MergeField))
+		d.fieldPath = d.fieldPath[:len(d.fieldPath)-1]
+	} else if mergeNode != nil {
+		merged = d.merge(n, mergeNode, out)
+	}
+	// Keys provided through a merge are only known once the outermost
+	// mapping is done, so leave required fields and defaults to it.
+	if mergedFields == nil {
+		for _, id := range sinfo.Required {
+			info := sinfo.FieldsList[id]
+			if !doneFields[id] && !merged[info.Key] && !(d.caseInsensitive && merged[foldedKey(foldKey(info.Key))]) {
+				path := formatPath(append(d.fieldPath, pathElem{key: reflect.ValueOf(info.Key)}))
+				d.terrors = append(d.terrors, fmt.Sprintf("line %d: required field %s not set in type %s", n.Line, path, out.Type()))
+			}
+		}
+		d.setDefaults(n, out, sinfo, func(info *fieldInfo) bool {
+			return doneFields[info.Id] || merged[info.Key] || d.caseInsensitive && merged[foldedKey(foldKey(info.Key))]
+		})
 	}
 	return true
 }
 
+// setDefaults sets the fields of out that have a default value, that
+// are still zero, and for which done returns false. Nested structs that
+// weren't provided at all have their own default values set as well.
+func (d *decoder) setDefaults(n *Node, out reflect.Value, sinfo *structInfo, done func(info *fieldInfo) bool) {
+	for _, id := range sinfo.Defaults {
+		info := &sinfo.FieldsList[id]
+		if done != nil && done(info) {
+			continue
+		}
+		var field reflect.Value
+		if info.Inline == nil {
+			field = out.Field(info.Num)
+		} else {
+			field = d.fieldByIndex(n, out, info.Inline)
+		}
+		if info.Default != nil {
+			// A default isn't applied again within its own value,
+			// which would never end for recursive types.
+			if isZero(field) && !d.defaulting[info] {
+				d.setDefault(out.Type(), info, field)
+			}
+			continue
+		}
+		fsinfo, err := getStructInfo(field.Type(), d.structOpts)
+		if err != nil {
+			panic(err)
+		}
+		d.setDefaults(n, field, fsinfo, nil)
+	}
+}
+
+// setDefault sets field, which belongs to a struct of type st, to the
+// default value described by info. The value is decoded on its own, so
+// options such as strict mode only apply to the decoded document. It
+// panics if the value can't be decoded into the field.
+func (d *decoder) setDefault(st reflect.Type, info *fieldInfo, field reflect.Value) {
+	if d.defaulting == nil {
+		d.defaulting = make(map[*fieldInfo]bool)
+	}
+	dd := newDecoder()
+	dd.structOpts = d.structOpts
+	dd.defaulting = d.defaulting
+	dd.defaulting[info] = true
+	value := reflect.New(field.Type()).Elem()
+	err := dd.decodeDefault(info.Default, value)
+	delete(d.defaulting, info)
+	if err != nil {
+		name := st.FieldByIndex(info.index()).Name
+		panic(errors.New(fmt.Sprintf("invalid default value %q for field %s of type %s: %v", info.DefaultText, name, st, err)))
+	}
+	field.Set(value)
+}
+
+// decodeDefault decodes the default value n into out.
+func (d *decoder) decodeDefault(n *Node, out reflect.Value) (err error) {
+	defer handleErr(&err)
+	d.unmarshal(n, out)
+	if len(d.terrors) > 0 {
+		return &TypeError{d.terrors}
+	}
+	return nil
+}
+
+// parseDefault parses the value of a "default" field tag. A nil node is
+// returned if the value is empty.
+func parseDefault(value string) (node *Node, err error) {
+	defer handleErr(&err)
+	p := newParser([]byte(value))
+	defer p.destroy()
+	doc := p.parse()
+	if doc == nil {
+		return nil, nil
+	}
+	return doc.Content[0], nil
+}
+
 func failWantMap() {
 	failf("map merge requires map or sequence of maps as the value")
 }
 
-func (d *decoder) merge(parent *Node, merge *Node, out reflect.Value) {
+// merge decodes the merge node into out, and returns the set of keys
+// that ended up defined either in parent or in the merged mappings.
+func (d *decoder) merge(parent *Node, merge *Node, out reflect.Value) (merged map[interface{}]bool) {
 	mergedFields := d.mergedFields
 	if mergedFields == nil {
 		d.mergedFields = make(map[interface{}]bool)
@@ -964,6 +1300,9 @@ func (d *decoder) merge(parent *Node, merge *Node, out reflect.Value) {
 			k := reflect.New(ifaceType).Elem()
 			if d.unmarshal(parent.Content[i], k) {
 				d.mergedFields[k.Interface()] = true
+				if s, ok := k.Interface().(string); ok && d.caseInsensitive {
+					d.mergedFields[foldedKey(foldKey(s))] = true
+				}
 			}
 		}
 	}
@@ -992,9 +1331,15 @@ func (d *decoder) merge(parent *Node, merge *Node, out reflect.Value) {
 		failWantMap()
 	}
 
+	merged = d.mergedFields
 	d.mergedFields = mergedFields
+	return merged
 }
 
+// foldedKey marks keys in merged field sets which hold the folded
+// form of a key, so they don't conflict with the actual keys.
+type foldedKey string
+
 func isMerge(n *Node) bool {
 	return n.Kind == ScalarNode && n.Value == "<<" && (n.Tag == "" || n.Tag == "!" || shortTag(n.Tag) == mergeTag)
 }
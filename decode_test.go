@@ -24,6 +24,7 @@ import (
 	"reflect"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/yaml.v3"
@@ -761,6 +762,36 @@ var unmarshalTests = []struct {
 		M{"ñoño": "very yes 🟔"},
 	},
 
+	// UTF-16 without BOM.
+	{
+		"\xf1\x00o\x00\xf1\x00o\x00:\x00 \x00v\x00e\x00r\x00y\x00 \x00y\x00e\x00s\x00 \x00=\xd8\xd4\xdf\n\x00",
+		M{"ñoño": "very yes 🟔"},
+	}, {
+		"\x00\xf1\x00o\x00\xf1\x00o\x00:\x00 \x00v\x00e\x00r\x00y\x00 \x00y\x00e\x00s\x00 \xd8=\xdf\xd4\x00\n",
+		M{"ñoño": "very yes 🟔"},
+	},
+
+	// UTF-32-LE
+	{
+		"\xff\xfe\x00\x00\xf1\x00\x00\x00o\x00\x00\x00\xf1\x00\x00\x00o\x00\x00\x00:\x00\x00\x00 \x00\x00\x00v\x00\x00\x00e\x00\x00\x00r\x00\x00\x00y\x00\x00\x00 \x00\x00\x00y\x00\x00\x00e\x00\x00\x00s\x00\x00\x00 \x00\x00\x00\xd4\xf7\x01\x00\n\x00\x00\x00",
+		M{"ñoño": "very yes 🟔"},
+	},
+
+	// UTF-32-BE
+	{
+		"\x00\x00\xfe\xff\x00\x00\x00\xf1\x00\x00\x00o\x00\x00\x00\xf1\x00\x00\x00o\x00\x00\x00:\x00\x00\x00 \x00\x00\x00v\x00\x00\x00e\x00\x00\x00r\x00\x00\x00y\x00\x00\x00 \x00\x00\x00y\x00\x00\x00e\x00\x00\x00s\x00\x00\x00 \x00\x01\xf7\xd4\x00\x00\x00\n",
+		M{"ñoño": "very yes 🟔"},
+	},
+
+	// UTF-32 without BOM.
+	{
+		"\xf1\x00\x00\x00o\x00\x00\x00\xf1\x00\x00\x00o\x00\x00\x00:\x00\x00\x00 \x00\x00\x00v\x00\x00\x00e\x00\x00\x00r\x00\x00\x00y\x00\x00\x00 \x00\x00\x00y\x00\x00\x00e\x00\x00\x00s\x00\x00\x00 \x00\x00\x00\xd4\xf7\x01\x00\n\x00\x00\x00",
+		M{"ñoño": "very yes 🟔"},
+	}, {
+		"\x00\x00\x00\xf1\x00\x00\x00o\x00\x00\x00\xf1\x00\x00\x00o\x00\x00\x00:\x00\x00\x00 \x00\x00\x00v\x00\x00\x00e\x00\x00\x00r\x00\x00\x00y\x00\x00\x00 \x00\x00\x00y\x00\x00\x00e\x00\x00\x00s\x00\x00\x00 \x00\x01\xf7\xd4\x00\x00\x00\n",
+		M{"ñoño": "very yes 🟔"},
+	},
+
 	// This *is* in fact a float number, per the spec. #171 was a mistake.
 	{
 		"a: 123456e1\n",
@@ -949,6 +980,8 @@ var unmarshalErrorTests = []struct {
 	{"a: 1\nb: 2\nc 2\nd: 3\n", "^yaml: line 3: could not find expected ':'$"},
 	{"#\n-\n{", "yaml: line 3: could not find expected ':'"}, // Issue #665
 	{"0: [:!00 \xef", "yaml: incomplete UTF-8 octet sequence"}, // Issue #666
+	{"\xff\xfe\x00\x00a\x00\x00", "yaml: incomplete UTF-32 character"},
+	{"\x00\x00\xfe\xff\x00\x11\x00\x00", "yaml: invalid Unicode character"},
 	{
 		"a: &a [00,00,00,00,00,00,00,00,00]\n" +
 			"b: &b [*a,*a,*a,*a,*a,*a,*a,*a,*a]\n" +
@@ -972,6 +1005,63 @@ func (s *S) TestUnmarshalErrors(c *C) {
 	}
 }
 
+// encodeText returns the text in the given encoding, with the
+// byte order mark if bom is set.
+func encodeText(text string, encoding string, bom bool) []byte {
+	var out []byte
+	runes := []rune(text)
+	if bom {
+		runes = append([]rune{0xFEFF}, runes...)
+	}
+	for _, r := range runes {
+		switch encoding {
+		case "utf-16le", "utf-16be":
+			units := []uint16{uint16(r)}
+			if r >= 0x10000 {
+				r1, r2 := utf16.EncodeRune(r)
+				units = []uint16{uint16(r1), uint16(r2)}
+			}
+			for _, u := range units {
+				if encoding == "utf-16le" {
+					out = append(out, byte(u), byte(u>>8))
+				} else {
+					out = append(out, byte(u>>8), byte(u))
+				}
+			}
+		case "utf-32le":
+			out = append(out, byte(r), byte(r>>8), byte(r>>16), byte(r>>24))
+		case "utf-32be":
+			out = append(out, byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+		default:
+			out = append(out, string(r)...)
+		}
+	}
+	return out
+}
+
+func (s *S) TestUnmarshalEncodingPositions(c *C) {
+	const data = "a: ñ\nb: [🟔, c]\nd: 1\n"
+	const bad = "a: ñ\nb: 🟔\n- c\n"
+	for _, encoding := range []string{"utf-8", "utf-16le", "utf-16be", "utf-32le", "utf-32be"} {
+		for _, bom := range []bool{false, true} {
+			c.Logf("encoding %s, bom %v", encoding, bom)
+			var node yaml.Node
+			c.Assert(yaml.Unmarshal(encodeText(data, encoding, bom), &node), IsNil)
+			d := node.Content[0].Content[5]
+			c.Assert(d.Value, Equals, "1")
+			c.Assert(d.Line, Equals, 3)
+			c.Assert(d.Column, Equals, 4)
+			seq := node.Content[0].Content[3]
+			c.Assert(seq.Content[1].Line, Equals, 2)
+			c.Assert(seq.Content[1].Column, Equals, 8)
+
+			var value interface{}
+			err := yaml.Unmarshal(encodeText(bad, encoding, bom), &value)
+			c.Assert(err, ErrorMatches, "yaml: line 2: did not find expected key")
+		}
+	}
+}
+
 func (s *S) TestDecoderErrors(c *C) {
 	for _, item := range unmarshalErrorTests {
 		var value interface{}
@@ -1703,6 +1793,443 @@ func (s *S) TestUnmarshalKnownFields(c *C) {
 	}
 }
 
+type strictConfig struct {
+	Name    string
+	Version string
+	Tags    map[string]string
+	Any     interface{}
+}
+
+var decoderStrictTests = []struct {
+	data  string
+	error string
+}{{
+	data:  "name: a\nname: b\n",
+	error: `line 2: mapping key "name" already defined at line 1`,
+}, {
+	data:  "name: a\nother: b\n",
+	error: `line 2: field other not found in type yaml_test.strictConfig`,
+}, {
+	data:  "tags:\n  <<: {a: b}\n",
+	error: `line 2: merge key is not allowed in strict mode`,
+}, {
+	data:  "name: &n a\nversion: *n\n",
+	error: `line 2: alias \*n is not allowed in strict mode`,
+}, {
+	data:  "any: {1: a}\n",
+	error: `line 1: non-string key !!int ` + "`1`" + ` is not allowed in strict mode`,
+}, {
+	data:  "tags: {[a]: b}\n",
+	error: `line 1: non-string key !!seq is not allowed in strict mode`,
+}, {
+	data:  "version: 1.10\n",
+	error: `line 1: cannot unmarshal !!float ` + "`1.10`" + ` into string`,
+}, {
+	data:  "name: true\n",
+	error: `line 1: cannot unmarshal !!bool ` + "`true`" + ` into string`,
+}, {
+	data:  "any: !!binary aGVsbG8=\n",
+	error: `line 1: tag !!binary is not allowed in strict mode`,
+}, {
+	data:  "any: !custom value\n",
+	error: `line 1: tag !custom is not allowed in strict mode`,
+}}
+
+func (s *S) TestDecoderStrict(c *C) {
+	for i, item := range decoderStrictTests {
+		c.Logf("test %d: %q", i, item.data)
+		var v strictConfig
+		dec := yaml.NewDecoder(strings.NewReader(item.data))
+		dec.Strict()
+		err := dec.Decode(&v)
+		c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  "+item.error)
+	}
+
+	// Plain strings, quoted values, and explicitly allowed tags are fine.
+	data := "name: app\nversion: '1.10'\ntags: {a: b}\nany: !!str 1\n---\nany: !custom x\n"
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.Strict()
+	dec.AllowTags("!custom")
+	var v strictConfig
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v, DeepEquals, strictConfig{Name: "app", Version: "1.10", Tags: map[string]string{"a": "b"}, Any: "1"})
+	v = strictConfig{}
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v, DeepEquals, strictConfig{Any: "x"})
+}
+
+func (s *S) TestDecoderStrictStrings(c *C) {
+	type Version string
+	type T struct {
+		Name    string
+		Version Version
+		Port    int
+		Any     interface{}
+		Names   []string
+	}
+	data := "" +
+		"name: true\n" +
+		"version: 1.20\n" +
+		"port: 80\n" +
+		"any: 1.20\n" +
+		"names: [a, 'true', !!str 1, 2, !!int \"5\"]\n"
+
+	// Without the option the text of the scalars is kept.
+	var v T
+	c.Assert(yaml.Unmarshal([]byte(data), &v), IsNil)
+	c.Assert(v, DeepEquals, T{Name: "true", Version: "1.20", Port: 80, Any: 1.2, Names: []string{"a", "true", "1", "2", "5"}})
+
+	v = T{}
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.StrictStrings(true)
+	err := dec.Decode(&v)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n"+
+		"  line 1: cannot unmarshal !!bool `true` into string\n"+
+		"  line 2: cannot unmarshal !!float `1.20` into yaml_test.Version\n"+
+		"  line 5: cannot unmarshal !!int `2` into string\n"+
+		"  line 5: cannot unmarshal !!int `5` into string")
+	c.Assert(v, DeepEquals, T{Port: 80, Any: 1.2, Names: []string{"a", "true", "1"}})
+}
+
+type requiredInner struct {
+	Port int    `yaml:"port,required"`
+	Host string `yaml:"host,required"`
+}
+
+var unmarshalRequiredTests = []struct {
+	data  string
+	value interface{}
+	error string
+}{{
+	data: "a: 1\nb: 2\n",
+	value: &struct {
+		A int `yaml:"a,required"`
+		B int `yaml:"b,required"`
+	}{},
+}, {
+	data:  "port: 1\n",
+	value: &requiredInner{},
+	error: "yaml: unmarshal errors:\n  line 1: required field host not set in type yaml_test.requiredInner",
+}, {
+	data:  "{}",
+	value: &requiredInner{},
+	error: "yaml: unmarshal errors:\n" +
+		"  line 1: required field port not set in type yaml_test.requiredInner\n" +
+		"  line 1: required field host not set in type yaml_test.requiredInner",
+}, {
+	data: "server:\n  port: 80\nlist: [x]\n",
+	value: &struct {
+		Server requiredInner `yaml:"server"`
+		List   []int         `yaml:"list"`
+	}{},
+	error: "yaml: unmarshal errors:\n" +
+		"  line 2: required field server.host not set in type yaml_test.requiredInner\n" +
+		"  line 3: cannot unmarshal !!str `x` into int",
+}, {
+	data: "inner:\n  port: 80\n",
+	value: &struct {
+		Inner struct {
+			requiredInner `yaml:",inline"`
+		}
+	}{},
+	error: "yaml: unmarshal errors:\n  line 2: required field inner.host not set in type .*",
+}, {
+	data: "base: &base\n  host: localhost\nserver:\n  <<: *base\n  port: 80\n",
+	value: &struct {
+		Base   map[string]string
+		Server requiredInner
+	}{},
+}, {
+	data: "server:\n  <<: {port: 80}\n",
+	value: &struct {
+		Server requiredInner
+	}{},
+	error: "yaml: unmarshal errors:\n  line 2: required field server.host not set in type yaml_test.requiredInner",
+}, {
+	data: "servers: [{port: 1}, {port: 2, host: a}, {port: 3}]\n",
+	value: &struct {
+		Servers []requiredInner
+	}{},
+	error: "yaml: unmarshal errors:\n" +
+		"  line 1: required field servers\\[0\\].host not set in type yaml_test.requiredInner\n" +
+		"  line 1: required field servers\\[2\\].host not set in type yaml_test.requiredInner",
+}, {
+	data: "byname:\n  x: {port: 1}\n",
+	value: &struct {
+		ByName map[string]requiredInner
+	}{},
+	error: "yaml: unmarshal errors:\n  line 2: required field byname.x.host not set in type yaml_test.requiredInner",
+}}
+
+func (s *S) TestUnmarshalRequired(c *C) {
+	for i, item := range unmarshalRequiredTests {
+		c.Logf("test %d: %q", i, item.data)
+		err := yaml.Unmarshal([]byte(item.data), item.value)
+		if item.error == "" {
+			c.Assert(err, IsNil)
+		} else {
+			c.Assert(err, ErrorMatches, item.error)
+		}
+	}
+}
+
+type defaultsInner struct {
+	Port  int      `yaml:"port" default:"8080"`
+	Hosts []string `yaml:"hosts" default:"[a, b]"`
+}
+
+type defaultsOuter struct {
+	Name   string         `default:"none"`
+	Inner  defaultsInner  `yaml:"inner"`
+	PInner *defaultsInner `yaml:"pinner"`
+	List   []defaultsInner
+	Embed  struct {
+		defaultsInner `yaml:",inline"`
+	}
+}
+
+var unmarshalDefaultsTests = []struct {
+	data  string
+	value defaultsOuter
+}{{
+	data: "{}",
+	value: defaultsOuter{
+		Name:  "none",
+		Inner: defaultsInner{8080, []string{"a", "b"}},
+		Embed: struct {
+			defaultsInner `yaml:",inline"`
+		}{defaultsInner{8080, []string{"a", "b"}}},
+	},
+}, {
+	data: "name: n\ninner: {port: 1}\npinner: {hosts: [c]}\nlist: [{}, {port: 2, hosts: []}]\nembed: {port: 3}\n",
+	value: defaultsOuter{
+		Name:   "n",
+		Inner:  defaultsInner{1, []string{"a", "b"}},
+		PInner: &defaultsInner{8080, []string{"c"}},
+		List: []defaultsInner{
+			{8080, []string{"a", "b"}},
+			{2, []string{}},
+		},
+		Embed: struct {
+			defaultsInner `yaml:",inline"`
+		}{defaultsInner{3, []string{"a", "b"}}},
+	},
+}, {
+	data: "base: &base {port: 1}\ninner:\n  <<: *base\n",
+	value: defaultsOuter{
+		Name:  "none",
+		Inner: defaultsInner{1, []string{"a", "b"}},
+		Embed: struct {
+			defaultsInner `yaml:",inline"`
+		}{defaultsInner{8080, []string{"a", "b"}}},
+	},
+}}
+
+func (s *S) TestUnmarshalDefaults(c *C) {
+	for i, item := range unmarshalDefaultsTests {
+		c.Logf("test %d: %q", i, item.data)
+		var value defaultsOuter
+		err := yaml.Unmarshal([]byte(item.data), &value)
+		c.Assert(err, IsNil)
+		c.Assert(value, DeepEquals, item.value)
+	}
+}
+
+func (s *S) TestUnmarshalDefaultsKeepValues(c *C) {
+	value := defaultsOuter{
+		Name:  "n",
+		Inner: defaultsInner{Port: 9000},
+	}
+	err := yaml.Unmarshal([]byte("{}"), &value)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, defaultsOuter{
+		Name:  "n",
+		Inner: defaultsInner{9000, []string{"a", "b"}},
+		Embed: struct {
+			defaultsInner `yaml:",inline"`
+		}{defaultsInner{8080, []string{"a", "b"}}},
+	})
+}
+
+type defaultsRec struct {
+	A    int          `default:"1"`
+	Next *defaultsRec `default:"{}"`
+}
+
+type defaultsTree struct {
+	Name     string         `default:"leaf"`
+	Children []defaultsTree `default:"[{}]"`
+}
+
+func (s *S) TestUnmarshalDefaultsRecursive(c *C) {
+	// A default isn't applied again within its own value.
+	var r defaultsRec
+	c.Assert(yaml.Unmarshal([]byte("a: 2"), &r), IsNil)
+	c.Assert(r, DeepEquals, defaultsRec{A: 2, Next: &defaultsRec{A: 1}})
+
+	var t defaultsTree
+	c.Assert(yaml.Unmarshal([]byte("{}"), &t), IsNil)
+	c.Assert(t, DeepEquals, defaultsTree{Name: "leaf", Children: []defaultsTree{{Name: "leaf"}}})
+}
+
+func (s *S) TestUnmarshalDefaultsStrict(c *C) {
+	// Default values aren't subject to the options of the decoder.
+	var v struct {
+		Version string `default:"1.10"`
+		Port    int    `default:"!!int 80"`
+	}
+	dec := yaml.NewDecoder(strings.NewReader("{}"))
+	dec.Strict()
+	dec.StrictStrings(true)
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v.Version, Equals, "1.10")
+	c.Assert(v.Port, Equals, 80)
+}
+
+type defaultsBad struct {
+	A int `yaml:"a,bogus"`
+}
+
+func (s *S) TestUnmarshalDefaultsSkipsOtherStructs(c *C) {
+	// Struct fields without defaults below them are not inspected
+	// unless they are decoded into.
+	var value struct {
+		X int
+		B defaultsBad
+	}
+	err := yaml.Unmarshal([]byte("x: 1"), &value)
+	c.Assert(err, IsNil)
+	c.Assert(value.X, Equals, 1)
+}
+
+func (s *S) TestUnmarshalInvalidDefault(c *C) {
+	var value struct {
+		A int `default:"foo"`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("{}"), &value) }, PanicMatches, `invalid default value "foo" for field A of type struct .*: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!str .foo. into int`)
+}
+
+var unmarshalCaseInsensitiveTests = []struct {
+	data  string
+	value interface{}
+	error string
+}{{
+	data:  "TIMEOUT: 1\nName: a\n",
+	value: struct{ Timeout, Name interface{} }{1, "a"},
+}, {
+	data: "Timeout: 1\ntimeout: 2\n",
+	value: struct {
+		A int `yaml:"Timeout"`
+		B int `yaml:"timeout"`
+	}{1, 2},
+}, {
+	data: "TIMEOUT: 1\n",
+	value: struct {
+		A int `yaml:"Timeout"`
+		B int `yaml:"timeout"`
+	}{},
+	error: `yaml: unmarshal errors:\n  line 1: field TIMEOUT matches multiple fields ignoring case in type .*`,
+}, {
+	data:  "Timeout: 1\ntimeout: 2\n",
+	value: struct{ Timeout int }{1},
+	error: `yaml: unmarshal errors:\n  line 2: field timeout conflicts with field Timeout ignoring case in type .*`,
+}, {
+	data:  "base: &base {Timeout: 1, NAME: b}\nvalue:\n  <<: *base\n  name: a\n",
+	value: struct {
+		Base  map[string]interface{}
+		Value struct{ Timeout, Name interface{} }
+	}{
+		map[string]interface{}{"Timeout": 1, "NAME": "b"},
+		struct{ Timeout, Name interface{} }{1, "a"},
+	},
+}, {
+	data:  "Timeout: 1\n",
+	value: struct{ Other int }{},
+	error: `yaml: unmarshal errors:\n  line 1: field Timeout not found in type .*`,
+}}
+
+func (s *S) TestUnmarshalCaseInsensitive(c *C) {
+	for i, item := range unmarshalCaseInsensitiveTests {
+		c.Logf("test %d: %q", i, item.data)
+		t := reflect.ValueOf(item.value).Type()
+		value := reflect.New(t)
+		dec := yaml.NewDecoder(strings.NewReader(item.data))
+		dec.KnownFields(true)
+		dec.CaseInsensitive(true)
+		err := dec.Decode(value.Interface())
+		if item.error == "" {
+			c.Assert(err, IsNil)
+			c.Assert(value.Elem().Interface(), DeepEquals, item.value)
+		} else {
+			c.Assert(err, ErrorMatches, item.error)
+		}
+	}
+}
+
+var orderedMapsTests = []struct {
+	data  string
+	value interface{}
+}{{
+	"b: 1\na: 2\nc: [{z: 1, y: 2}]\n",
+	yaml.MapSlice{
+		{"b", 1},
+		{"a", 2},
+		{"c", []interface{}{yaml.MapSlice{{"z", 1}, {"y", 2}}}},
+	},
+}, {
+	"b: &b {d: 1, c: 2}\na:\n  <<: *b\n  c: 3\n  e: 4\n",
+	yaml.MapSlice{
+		{"b", yaml.MapSlice{{"d", 1}, {"c", 2}}},
+		{"a", yaml.MapSlice{{"c", 3}, {"e", 4}, {"d", 1}}},
+	},
+}, {
+	"2: two\n1: one\n",
+	yaml.MapSlice{{2, "two"}, {1, "one"}},
+}}
+
+func (s *S) TestOrderedMaps(c *C) {
+	for i, item := range orderedMapsTests {
+		c.Logf("test %d: %q", i, item.data)
+
+		var value interface{}
+		dec := yaml.NewDecoder(strings.NewReader(item.data))
+		dec.OrderedMaps(true)
+		c.Assert(dec.Decode(&value), IsNil)
+		c.Assert(value, DeepEquals, item.value)
+
+		// Decoding into a MapSlice implies ordered maps in nested values.
+		var slice yaml.MapSlice
+		c.Assert(yaml.Unmarshal([]byte(item.data), &slice), IsNil)
+		c.Assert(slice, DeepEquals, item.value)
+	}
+
+	// Explicit map types are still honoured.
+	var value interface{}
+	dec := yaml.NewDecoder(strings.NewReader("a: {c: 1, b: 2}\nb: 3\n"))
+	dec.OrderedMaps(true)
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value, DeepEquals, yaml.MapSlice{{"a", yaml.MapSlice{{"c", 1}, {"b", 2}}}, {"b", 3}})
+
+	var m map[string]interface{}
+	dec = yaml.NewDecoder(strings.NewReader("a: {c: 1, b: 2}\n"))
+	dec.OrderedMaps(true)
+	c.Assert(dec.Decode(&m), IsNil)
+	c.Assert(m, DeepEquals, map[string]interface{}{"a": map[string]interface{}{"c": 1, "b": 2}})
+
+	var ints []int
+	err := yaml.Unmarshal([]byte("a: 1"), &ints)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  line 1: cannot unmarshal !!map into \\[\\]int")
+
+	// Keys that can't be map keys are rejected as with maps, even
+	// when they'd only be looked up while merging.
+	for _, data := range []string{"? [a]\n: 1\n", "? [a]\n: 1\n<<: {b: 2}\n", "? {a: 1}\n: 1\n<<: {b: 2}\n"} {
+		var v interface{}
+		dec = yaml.NewDecoder(strings.NewReader(data))
+		dec.OrderedMaps(true)
+		c.Assert(dec.Decode(&v), ErrorMatches, "yaml: invalid map key: .*")
+	}
+}
+
 type textUnmarshaler struct {
 	S string
 }
@@ -1769,3 +2296,67 @@ func (s *S) TestFuzzCrashers(c *C) {
 //		yaml.Marshal(&v)
 //	}
 //}
+
+func (s *S) TestUnmarshalMergeField(c *C) {
+	type Base struct {
+		Image string
+		Tags  []string
+	}
+	type Service struct {
+		Base  *Base `yaml:",merge"`
+		Image string
+		Name  string
+	}
+	data := "" +
+		"base: &base\n" +
+		"  image: app\n" +
+		"  tags: [x]\n" +
+		"services:\n" +
+		"  - <<: *base\n" +
+		"    name: a\n" +
+		"  - name: b\n" +
+		"    image: other\n"
+	var v struct {
+		Base     *Base
+		Services []Service
+	}
+	c.Assert(yaml.Unmarshal([]byte(data), &v), IsNil)
+	base := &Base{Image: "app", Tags: []string{"x"}}
+	c.Assert(v.Base, DeepEquals, base)
+	c.Assert(v.Services, DeepEquals, []Service{
+		{Base: base, Name: "a"},
+		{Name: "b", Image: "other"},
+	})
+
+	// The field may also hold several merged mappings.
+	type Multi struct {
+		Bases []map[string]int `yaml:",merge"`
+		C     int
+	}
+	var m Multi
+	c.Assert(yaml.Unmarshal([]byte("<<: [{a: 1}, {b: 2}]\nc: 3\n"), &m), IsNil)
+	c.Assert(m, DeepEquals, Multi{Bases: []map[string]int{{"a": 1}, {"b": 2}}, C: 3})
+}
+
+func (s *S) TestUnmarshalSet(c *C) {
+	data := "!!set\n? a\n? b\n"
+	var set map[string]struct{}
+	c.Assert(yaml.Unmarshal([]byte(data), &set), IsNil)
+	c.Assert(set, DeepEquals, map[string]struct{}{"a": {}, "b": {}})
+
+	var flags map[string]bool
+	c.Assert(yaml.Unmarshal([]byte(data), &flags), IsNil)
+	c.Assert(flags, DeepEquals, map[string]bool{"a": true, "b": true})
+
+	var v interface{}
+	c.Assert(yaml.Unmarshal([]byte("!!set {1, 2}"), &v), IsNil)
+	c.Assert(v, DeepEquals, map[interface{}]interface{}{1: nil, 2: nil})
+
+	var node yaml.Node
+	c.Assert(yaml.Unmarshal([]byte(data), &node), IsNil)
+	c.Assert(node.Content[0].ShortTag(), Equals, "!!set")
+	c.Assert(node.Content[0].Tag, Equals, "!!set")
+
+	err := yaml.Unmarshal([]byte("!!set\n? a\nb: 1\n"), &flags)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  line 3: !!set entries must have null values")
+}
@@ -25,6 +25,7 @@ package yaml
 import (
 	"bytes"
 	"fmt"
+	"unicode/utf8"
 )
 
 // Flush the buffer if needed.
@@ -346,7 +347,7 @@ func yaml_emitter_emit_stream_start(emitter *yaml_emitter_t, event *yaml_event_t
 	emitter.space_above = true
 	emitter.foot_indent = -1
 
-	if emitter.encoding != yaml_UTF8_ENCODING {
+	if emitter.encoding != yaml_UTF8_ENCODING || emitter.utf8_bom {
 		if !yaml_emitter_write_bom(emitter) {
 			return false
 		}
@@ -402,7 +403,9 @@ func yaml_emitter_emit_document_start(emitter *yaml_emitter_t, event *yaml_event
 			if !yaml_emitter_write_indicator(emitter, []byte("%YAML"), true, false, false) {
 				return false
 			}
-			if !yaml_emitter_write_indicator(emitter, []byte("1.1"), true, false, false) {
+			// [Go] Write the actual version rather than always 1.1.
+			version := []byte{'0' + byte(event.version_directive.major), '.', '0' + byte(event.version_directive.minor)}
+			if !yaml_emitter_write_indicator(emitter, version, true, false, false) {
 				return false
 			}
 			if !yaml_emitter_write_indent(emitter) {
@@ -519,6 +522,10 @@ func yaml_emitter_emit_document_end(emitter *yaml_emitter_t, event *yaml_event_t
 		if !yaml_emitter_write_indent(emitter) {
 			return false
 		}
+		emitter.open_ended = false
+	} else {
+		// [Go] Directives of a following document require an explicit end.
+		emitter.open_ended = true
 	}
 	if !yaml_emitter_flush(emitter) {
 		return false
@@ -651,6 +658,7 @@ func yaml_emitter_emit_flow_mapping_key(emitter *yaml_emitter_t, event *yaml_eve
 		}
 		emitter.state = emitter.states[len(emitter.states)-1]
 		emitter.states = emitter.states[:len(emitter.states)-1]
+		emitter.sets = emitter.sets[:len(emitter.sets)-1]
 		return true
 	}
 
@@ -689,6 +697,11 @@ func yaml_emitter_emit_flow_mapping_key(emitter *yaml_emitter_t, event *yaml_eve
 
 // Expect a flow value node.
 func yaml_emitter_emit_flow_mapping_value(emitter *yaml_emitter_t, event *yaml_event_t, simple bool) bool {
+	if emitter.sets[len(emitter.sets)-1] && yaml_emitter_set_nil_event(emitter, event) {
+		// [Go] Set entries have no value.
+		emitter.state = yaml_EMIT_FLOW_MAPPING_KEY_STATE
+		return true
+	}
 	if simple {
 		if !yaml_emitter_write_indicator(emitter, []byte{':'}, false, false, false) {
 			return false
@@ -728,7 +741,9 @@ func yaml_emitter_emit_flow_mapping_value(emitter *yaml_emitter_t, event *yaml_e
 // Expect a block item node.
 func yaml_emitter_emit_block_sequence_item(emitter *yaml_emitter_t, event *yaml_event_t, first bool) bool {
 	if first {
-		if !yaml_emitter_increase_indent(emitter, false, false) {
+		// [Go] Sequences are only left indentless on request.
+		indentless := emitter.indentless_sequences && emitter.mapping_context && !emitter.indention
+		if !yaml_emitter_increase_indent(emitter, false, indentless) {
 			return false
 		}
 	}
@@ -776,6 +791,7 @@ func yaml_emitter_emit_block_mapping_key(emitter *yaml_emitter_t, event *yaml_ev
 		emitter.indents = emitter.indents[:len(emitter.indents)-1]
 		emitter.state = emitter.states[len(emitter.states)-1]
 		emitter.states = emitter.states[:len(emitter.states)-1]
+		emitter.sets = emitter.sets[:len(emitter.sets)-1]
 		return true
 	}
 	if !yaml_emitter_write_indent(emitter) {
@@ -788,7 +804,7 @@ func yaml_emitter_emit_block_mapping_key(emitter *yaml_emitter_t, event *yaml_ev
 		emitter.key_line_comment = emitter.line_comment
 		emitter.line_comment = nil
 	}
-	if yaml_emitter_check_simple_key(emitter) {
+	if !emitter.sets[len(emitter.sets)-1] && yaml_emitter_check_simple_key(emitter) {
 		emitter.states = append(emitter.states, yaml_EMIT_BLOCK_MAPPING_SIMPLE_VALUE_STATE)
 		return yaml_emitter_emit_node(emitter, event, false, false, true, true)
 	}
@@ -801,6 +817,11 @@ func yaml_emitter_emit_block_mapping_key(emitter *yaml_emitter_t, event *yaml_ev
 
 // Expect a block value node.
 func yaml_emitter_emit_block_mapping_value(emitter *yaml_emitter_t, event *yaml_event_t, simple bool) bool {
+	if !simple && emitter.sets[len(emitter.sets)-1] && yaml_emitter_set_nil_event(emitter, event) {
+		// [Go] Set entries have no value.
+		emitter.state = yaml_EMIT_BLOCK_MAPPING_KEY_STATE
+		return true
+	}
 	if simple {
 		if !yaml_emitter_write_indicator(emitter, []byte{':'}, false, false, false) {
 			return false
@@ -851,6 +872,19 @@ func yaml_emitter_silent_nil_event(emitter *yaml_emitter_t, event *yaml_event_t)
 	return event.typ == yaml_SCALAR_EVENT && event.implicit && !emitter.canonical && len(emitter.scalar_data.value) == 0
 }
 
+// [Go] Check if the event is the null value of a set entry, which may be
+// left out whether it is written as an empty scalar, as null, or as ~.
+func yaml_emitter_set_nil_event(emitter *yaml_emitter_t, event *yaml_event_t) bool {
+	if event.typ != yaml_SCALAR_EVENT || !event.implicit || emitter.canonical {
+		return false
+	}
+	switch string(emitter.scalar_data.value) {
+	case "", "null", "~":
+		return true
+	}
+	return false
+}
+
 // Expect a node.
 func yaml_emitter_emit_node(emitter *yaml_emitter_t, event *yaml_event_t,
 	root bool, sequence bool, mapping bool, simple_key bool) bool {
@@ -940,6 +974,8 @@ func yaml_emitter_emit_mapping_start(emitter *yaml_emitter_t, event *yaml_event_
 	} else {
 		emitter.state = yaml_EMIT_BLOCK_MAPPING_FIRST_KEY_STATE
 	}
+	// [Go] The entries of sets are written without values.
+	emitter.sets = append(emitter.sets, string(event.tag) == yaml_SET_TAG)
 	return true
 }
 
@@ -1181,7 +1217,8 @@ func yaml_emitter_process_foot_comment(emitter *yaml_emitter_t) bool {
 
 // Check if a %YAML directive is valid.
 func yaml_emitter_analyze_version_directive(emitter *yaml_emitter_t, version_directive *yaml_version_directive_t) bool {
-	if version_directive.major != 1 || version_directive.minor != 1 {
+	// [Go] Accept YAML 1.2 documents as well.
+	if version_directive.major != 1 || (version_directive.minor != 1 && version_directive.minor != 2) {
 		return yaml_emitter_set_emitter_error(emitter, "incompatible %YAML directive")
 	}
 	return true
@@ -1792,7 +1829,9 @@ func yaml_emitter_write_double_quoted_scalar(emitter *yaml_emitter_t, value []by
 			case 0x2029:
 				ok = put(emitter, 'P')
 			default:
-				if v <= 0xFF {
+				// [Go] Escape non-ASCII characters as \u or \U in ASCII-only
+				// output, as some consumers don't support \x for those.
+				if v <= 0xFF && (emitter.unicode || v < 0x80) {
 					ok = put(emitter, 'x')
 					w = 2
 				} else if v <= 0xFFFF {
@@ -1802,14 +1841,7 @@ func yaml_emitter_write_double_quoted_scalar(emitter *yaml_emitter_t, value []by
 					ok = put(emitter, 'U')
 					w = 8
 				}
-				for k := (w - 1) * 4; ok && k >= 0; k -= 4 {
-					digit := byte((v >> uint(k)) & 0x0F)
-					if digit < 10 {
-						ok = put(emitter, digit+'0')
-					} else {
-						ok = put(emitter, digit+'A'-10)
-					}
-				}
+				ok = ok && put_hex(emitter, v, w)
 			}
 			if !ok {
 				return false
@@ -1982,6 +2014,21 @@ func yaml_emitter_write_folded_scalar(emitter *yaml_emitter_t, value []byte) boo
 	return true
 }
 
+// [Go] Write the character v as w hexadecimal digits.
+func put_hex(emitter *yaml_emitter_t, v rune, w int) bool {
+	for k := (w - 1) * 4; k >= 0; k -= 4 {
+		digit := byte((v >> uint(k)) & 0x0F)
+		if digit < 10 {
+			if !put(emitter, digit+'0') {
+				return false
+			}
+		} else if !put(emitter, digit+'A'-10) {
+			return false
+		}
+	}
+	return true
+}
+
 func yaml_emitter_write_comment(emitter *yaml_emitter_t, comment []byte) bool {
 	breaks := false
 	pound := false
@@ -2003,7 +2050,19 @@ func yaml_emitter_write_comment(emitter *yaml_emitter_t, comment []byte) bool {
 				}
 				pound = true
 			}
-			if !write(emitter, comment, &i) {
+			if !emitter.unicode && !is_ascii(comment, i) {
+				// [Go] Comments can't be escaped, but escape sequences
+				// still keep ASCII-only output readable.
+				v, w := utf8.DecodeRune(comment[i:])
+				prefix, digits := byte('u'), 4
+				if v > 0xFFFF {
+					prefix, digits = 'U', 8
+				}
+				if !put(emitter, '\\') || !put(emitter, prefix) || !put_hex(emitter, v, digits) {
+					return false
+				}
+				i += w
+			} else if !write(emitter, comment, &i) {
 				return false
 			}
 			emitter.indention = false
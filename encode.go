@@ -35,6 +35,56 @@ type encoder struct {
 	flow     bool
 	indent   int
 	doneInit bool
+
+	structOpts structOptions
+
+	// quoteStyle holds the preferred style for quoted strings, and
+	// quoteAll whether strings are quoted even when unnecessary.
+	quoteStyle Style
+	quoteAll   bool
+
+	// explicitStart and explicitEnd define whether the document markers
+	// are always written, and version and tagDirectives hold the
+	// directives written at the start of every document.
+	explicitStart bool
+	explicitEnd   bool
+	version       *yaml_version_directive_t
+	tagDirectives []yaml_tag_directive_t
+
+	// lineBreak holds the style of line breaks, and preserveBreak
+	// whether document nodes use the style they were decoded with.
+	lineBreak     LineBreak
+	preserveBreak bool
+
+	// encoding holds the character encoding of the output.
+	encoding Encoding
+
+	// aliases enables anchors and aliases for values referenced more
+	// than once, which are held in shared with their anchor names once
+	// emitted. The anchor for the value being marshalled is pending until
+	// its node is emitted, when hasPending is set.
+	aliases    bool
+	shared     map[valueRef]string
+	pending    valueRef
+	hasPending bool
+	anchors    int
+
+	// ptrLevel holds the nesting depth of pointers, maps, and slices
+	// being marshalled, and ptrSeen those being marshalled past the
+	// depth where cycles are detected.
+	ptrLevel uint
+	ptrSeen  map[valueRef]seenValue
+
+	// keyLess defines the order of map keys, or nil for the natural order.
+	keyLess func(a, b reflect.Value) bool
+
+	// sets enables encoding maps with empty struct values as !!set.
+	sets bool
+
+	// keyOrder holds the node which defines the order of map keys found
+	// in it, and ref holds its node matching the value being encoded.
+	keyOrder *Node
+	ref      *Node
 }
 
 func newEncoder() *encoder {
@@ -61,7 +111,7 @@ func (e *encoder) init() {
 		e.indent = 4
 	}
 	e.emitter.best_indent = e.indent
-	yaml_stream_start_event_initialize(&e.event, yaml_UTF8_ENCODING)
+	yaml_stream_start_event_initialize(&e.event, e.encoding.yamlEncoding())
 	e.emit()
 	e.doneInit = true
 }
@@ -100,12 +150,92 @@ func (e *encoder) marshalDoc(tag string, in reflect.Value) {
 	if node != nil && node.Kind == DocumentNode {
 		e.nodev(in)
 	} else {
-		yaml_document_start_event_initialize(&e.event, nil, nil, true)
-		e.emit()
+		e.startDocument(nil)
+		e.ref = e.keyOrder
+		if e.ref != nil && e.ref.Kind == DocumentNode && len(e.ref.Content) == 1 {
+			e.ref = e.ref.Content[0]
+		}
+		e.ptrLevel = 0
+		e.ptrSeen = nil
+		if e.aliases {
+			e.shared = make(map[valueRef]string)
+			e.anchors = 0
+			counts := make(map[valueRef]int)
+			e.countRefs(counts, in)
+			for ref, n := range counts {
+				if n > 1 {
+					e.shared[ref] = ""
+				}
+			}
+		}
 		e.marshal(tag, in)
-		yaml_document_end_event_initialize(&e.event, true)
-		e.emit()
+		e.endDocument("")
+	}
+}
+
+// startDocument emits the start of a document. The directives and head
+// comment of the document node doc, if not nil, take precedence over the
+// encoder settings.
+func (e *encoder) startDocument(doc *Node) {
+	version, tags := e.version, e.tagDirectives
+	var head string
+	if doc != nil {
+		head = doc.HeadComment
+	}
+	if doc != nil && doc.Document != nil {
+		info := doc.Document
+		if info.Version != "" {
+			version = parseVersion(info.Version)
+			if version == nil {
+				failf("unsupported YAML version %q", info.Version)
+			}
+		}
+		if len(info.TagDirectives) > 0 {
+			tags = nil
+			handles := make(map[string]bool)
+			for _, tag := range info.TagDirectives {
+				handles[tag.Handle] = true
+				tags = append(tags, yaml_tag_directive_t{
+					handle: []byte(tag.Handle),
+					prefix: []byte(tag.Prefix),
+				})
+			}
+			for _, tag := range e.tagDirectives {
+				if !handles[string(tag.handle)] {
+					tags = append(tags, tag)
+				}
+			}
+		}
 	}
+	if e.preserveBreak {
+		b := e.lineBreak
+		if doc != nil && doc.Document != nil && doc.Document.LineBreak != 0 {
+			b = doc.Document.LineBreak
+		}
+		e.emitter.line_break = b.yamlBreak()
+	}
+	yaml_document_start_event_initialize(&e.event, version, tags, !e.explicitStart)
+	e.event.head_comment = []byte(head)
+	e.emit()
+}
+
+// endDocument emits the end of a document with the given foot comment.
+func (e *encoder) endDocument(foot string) {
+	yaml_document_end_event_initialize(&e.event, !e.explicitEnd)
+	e.event.foot_comment = []byte(foot)
+	e.emit()
+}
+
+// parseVersion returns the directive for a supported YAML version
+// in the "major.minor" form, or nil if it is not supported.
+func parseVersion(version string) *yaml_version_directive_t {
+	switch version {
+	case "1.1":
+		return &yaml_version_directive_t{major: 1, minor: 1}
+	case "1.2":
+		return &yaml_version_directive_t{major: 1, minor: 2}
+	}
+	return nil
 }
 
 func (e *encoder) marshal(tag string, in reflect.Value) {
@@ -114,6 +244,40 @@ func (e *encoder) marshal(tag string, in reflect.Value) {
 		e.nilv()
 		return
 	}
+	ref, ok := refOf(in)
+	if !ok {
+		e.marshalValue(tag, in)
+		return
+	}
+	if e.aliases {
+		if anchor, ok := e.shared[ref]; ok {
+			if anchor != "" {
+				e.hasPending = false
+				yaml_alias_event_initialize(&e.event, []byte(anchor))
+				e.emit()
+				return
+			}
+			e.pending, e.hasPending = ref, true
+		}
+	}
+	if e.ptrLevel++; e.ptrLevel > startDetectingCyclesAfter {
+		// Avoid the cost of tracking values at shallow depths,
+		// where cycles would be found eventually anyway.
+		if _, ok := e.ptrSeen[ref]; ok {
+			e.failCycle(ref, in)
+		}
+		if e.ptrSeen == nil {
+			e.ptrSeen = make(map[valueRef]seenValue)
+		}
+		e.ptrSeen[ref] = seenValue{e.ptrLevel, in}
+		defer delete(e.ptrSeen, ref)
+	}
+	e.marshalValue(tag, in)
+	e.ptrLevel--
+}
+
+// marshalValue marshals in, which is not nil, according to its type.
+func (e *encoder) marshalValue(tag string, in reflect.Value) {
 	iface := in.Interface()
 	switch value := iface.(type) {
 	case *Node:
@@ -136,6 +300,9 @@ func (e *encoder) marshal(tag string, in reflect.Value) {
 	case time.Duration:
 		e.stringv(tag, reflect.ValueOf(value.String()))
 		return
+	case MapSlice:
+		e.itemsv(tag, value)
+		return
 	case Marshaler:
 		v, err := value.MarshalYAML()
 		if err != nil {
@@ -161,7 +328,11 @@ func (e *encoder) marshal(tag string, in reflect.Value) {
 	case reflect.Interface:
 		e.marshal(tag, in.Elem())
 	case reflect.Map:
-		e.mapv(tag, in)
+		if e.sets && isSetType(in.Type()) {
+			e.setv(tag, in)
+		} else {
+			e.mapv(tag, in)
+		}
 	case reflect.Ptr:
 		e.marshal(tag, in.Elem())
 	case reflect.Struct:
@@ -183,15 +354,321 @@ func (e *encoder) marshal(tag string, in reflect.Value) {
 	}
 }
 
+const startDetectingCyclesAfter = 1000
+
+// pathElem holds either the mapping key or the sequence index
+// leading to a value.
+type pathElem struct {
+	key   reflect.Value
+	index int
+}
+
+// formatPath returns the path made of the given elements, such as "a.b[0]".
+func formatPath(path []pathElem) string {
+	var b strings.Builder
+	for _, elem := range path {
+		if !elem.key.IsValid() {
+			fmt.Fprintf(&b, "[%d]", elem.index)
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		fmt.Fprint(&b, elem.key.Interface())
+	}
+	return b.String()
+}
+
+// seenValue holds a value being marshalled past the depth where cycles
+// are detected, and the depth at which it is.
+type seenValue struct {
+	level uint
+	value reflect.Value
+}
+
+// failCycle reports the cycle found when marshalling the value in with
+// the identity ref again, along with the path leading from the outer
+// occurrence of the value to the inner one.
+func (e *encoder) failCycle(ref valueRef, in reflect.Value) {
+	start := e.ptrSeen[ref].level
+	chain := make([]reflect.Value, e.ptrLevel-start)
+	for _, seen := range e.ptrSeen {
+		if seen.level >= start {
+			chain[seen.level-start] = seen.value
+		}
+	}
+	var path []pathElem
+	for i, v := range chain {
+		next := ref
+		if i+1 < len(chain) {
+			next, _ = refOf(chain[i+1])
+		}
+		elems, _ := e.findRef(v, next, nil, true)
+		path = append(path, elems...)
+	}
+	p := formatPath(path)
+	fail(&UnsupportedValueError{
+		Value: in,
+		Path:  p,
+		Str:   fmt.Sprintf("encountered a cycle via %s at %s", in.Type(), p),
+	})
+}
+
+// findRef looks for the pointer, map, or slice identified by target among
+// the values held by in, without going through other such values, and
+// returns the path leading to it after the given prefix. The top flag is
+// set when in itself should not be compared to target.
+func (e *encoder) findRef(in reflect.Value, target valueRef, prefix []pathElem, top bool) ([]pathElem, bool) {
+	if !in.IsValid() {
+		return nil, false
+	}
+	if !top {
+		if ref, ok := refOf(in); ok {
+			return prefix, ref == target
+		}
+	}
+	switch in.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return e.findRef(in.Elem(), target, prefix, false)
+	case reflect.Map:
+		for _, k := range in.MapKeys() {
+			if path, ok := e.findRef(in.MapIndex(k), target, append(prefix, pathElem{key: k}), false); ok {
+				return path, true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < in.Len(); i++ {
+			var path []pathElem
+			var ok bool
+			if in.Type() == mapSliceType {
+				item := in.Index(i)
+				path, ok = e.findRef(item.Field(1), target, append(prefix, pathElem{key: item.Field(0)}), false)
+			} else {
+				path, ok = e.findRef(in.Index(i), target, append(prefix, pathElem{index: i}), false)
+			}
+			if ok {
+				return path, true
+			}
+		}
+	case reflect.Struct:
+		sinfo, err := getStructInfo(in.Type(), e.structOpts)
+		if err != nil {
+			return nil, false
+		}
+		for _, info := range sinfo.FieldsList {
+			var value reflect.Value
+			if info.Inline == nil {
+				value = in.Field(info.Num)
+			} else {
+				value = e.fieldByIndex(in, info.Inline)
+			}
+			if path, ok := e.findRef(value, target, append(prefix, pathElem{key: reflect.ValueOf(info.Key)}), false); ok {
+				return path, true
+			}
+		}
+		if sinfo.InlineMap >= 0 {
+			if path, ok := e.findRef(in.Field(sinfo.InlineMap), target, prefix, true); ok {
+				return path, true
+			}
+		}
+		if sinfo.MergeField >= 0 {
+			if path, ok := e.findRef(in.Field(sinfo.MergeField), target, append(prefix, pathElem{key: reflect.ValueOf("<<")}), false); ok {
+				return path, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// valueRef identifies a pointer, map, or slice value, whose content
+// may be referenced from several places.
+type valueRef struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// refOf returns the identity of the pointer, map, or non-empty slice in.
+func refOf(in reflect.Value) (valueRef, bool) {
+	switch in.Kind() {
+	case reflect.Ptr, reflect.Map:
+		if !in.IsNil() {
+			return valueRef{in.Pointer(), in.Type(), 0}, true
+		}
+	case reflect.Slice:
+		if in.Len() > 0 {
+			return valueRef{in.Pointer(), in.Type(), in.Len()}, true
+		}
+	}
+	return valueRef{}, false
+}
+
+// countRefs counts the references to the pointers, maps, and slices
+// reachable from in, as they would be marshalled.
+func (e *encoder) countRefs(counts map[valueRef]int, in reflect.Value) {
+	if !in.IsValid() {
+		return
+	}
+	if ref, ok := refOf(in); ok {
+		counts[ref]++
+		if counts[ref] > 1 {
+			return
+		}
+	}
+	switch in.Interface().(type) {
+	case *Node, Node, Marshaler, encoding.TextMarshaler:
+		return
+	}
+	switch in.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		e.countRefs(counts, in.Elem())
+	case reflect.Map:
+		for _, k := range in.MapKeys() {
+			e.countRefs(counts, in.MapIndex(k))
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < in.Len(); i++ {
+			e.countRefs(counts, in.Index(i))
+		}
+	case reflect.Struct:
+		sinfo, err := getStructInfo(in.Type(), e.structOpts)
+		if err != nil {
+			return
+		}
+		for _, info := range sinfo.FieldsList {
+			if info.Inline == nil {
+				e.countRefs(counts, in.Field(info.Num))
+			} else {
+				e.countRefs(counts, e.fieldByIndex(in, info.Inline))
+			}
+		}
+		if sinfo.InlineMap >= 0 {
+			e.countRefs(counts, in.Field(sinfo.InlineMap))
+		}
+		if sinfo.MergeField >= 0 {
+			e.countRefs(counts, in.Field(sinfo.MergeField))
+		}
+	}
+}
+
+// anchor returns the anchor for the node being emitted, which is the
+// next anchor name if a shared value is pending, or empty otherwise.
+func (e *encoder) anchor() []byte {
+	if !e.hasPending {
+		return nil
+	}
+	e.anchors++
+	anchor := fmt.Sprintf("id%03d", e.anchors)
+	e.shared[e.pending] = anchor
+	e.hasPending = false
+	return []byte(anchor)
+}
+
 func (e *encoder) mapv(tag string, in reflect.Value) {
+	ref := e.ref
 	e.mappingv(tag, func() {
-		keys := keyList(in.MapKeys())
-		sort.Sort(keys)
+		keys := in.MapKeys()
+		sortKeys(keys, e.keyLess, ref)
 		for _, k := range keys {
-			e.marshal("", k)
+			e.marshalKey(k)
+			e.ref = refValue(ref, k)
 			e.marshal("", in.MapIndex(k))
 		}
 	})
+	e.ref = ref
+}
+
+// isSetType returns whether t is a map with empty struct values.
+func isSetType(t reflect.Type) bool {
+	return t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
+// setv emits the keys of the map in as a !!set, with null values.
+func (e *encoder) setv(tag string, in reflect.Value) {
+	if tag == "" {
+		tag = setTag
+	}
+	ref := e.ref
+	e.mappingv(longTag(tag), func() {
+		keys := in.MapKeys()
+		sortKeys(keys, e.keyLess, ref)
+		for _, k := range keys {
+			e.marshalKey(k)
+			e.nilv()
+		}
+	})
+	e.ref = ref
+}
+
+func (e *encoder) itemsv(tag string, items MapSlice) {
+	ref := e.ref
+	e.mappingv(tag, func() {
+		for _, item := range items {
+			k := reflect.ValueOf(item.Key)
+			e.marshalKey(k)
+			e.ref = refValue(ref, k)
+			e.marshal("", reflect.ValueOf(item.Value))
+		}
+	})
+	e.ref = ref
+}
+
+// marshalKey marshals the mapping key k.
+func (e *encoder) marshalKey(k reflect.Value) {
+	e.ref = nil
+	if k.Kind() != reflect.Ptr && k.Kind() != reflect.Interface && !k.Type().Implements(textMarshalerType) && reflect.PtrTo(k.Type()).Implements(textMarshalerType) {
+		// Keys aren't addressable, so use a copy to reach a MarshalText
+		// method with a pointer receiver, as used when decoding them.
+		p := reflect.New(k.Type())
+		p.Elem().Set(k)
+		k = p
+	}
+	e.marshal("", k)
+}
+
+// quotedStyle returns the preferred style for quoted strings.
+func (e *encoder) quotedStyle() yaml_scalar_style_t {
+	if e.quoteStyle == SingleQuotedStyle {
+		return yaml_SINGLE_QUOTED_SCALAR_STYLE
+	}
+	return yaml_DOUBLE_QUOTED_SCALAR_STYLE
+}
+
+// quoteString returns whether string values with the given tag
+// must be quoted even when unnecessary.
+func (e *encoder) quoteString(tag string) bool {
+	return e.quoteAll && (tag == "" || tag == strTag)
+}
+
+// refValue returns the value for key k in the mapping node ref that
+// defines the order of keys, or nil if there's none.
+func refValue(ref *Node, k reflect.Value) *Node {
+	if ref == nil || ref.Kind != MappingNode {
+		return nil
+	}
+	key := keyText(k)
+	for i := 0; i+1 < len(ref.Content); i += 2 {
+		if ref.Content[i].Value == key {
+			return unalias(ref.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// refItem returns the item at index i in the sequence node ref that
+// defines the order of keys, or nil if there's none.
+func refItem(ref *Node, i int) *Node {
+	if ref == nil || ref.Kind != SequenceNode || i >= len(ref.Content) {
+		return nil
+	}
+	return unalias(ref.Content[i])
+}
+
+func unalias(n *Node) *Node {
+	if n.Kind == AliasNode && n.Alias != nil {
+		return n.Alias
+	}
+	return n
 }
 
 func (e *encoder) fieldByIndex(v reflect.Value, index []int) (field reflect.Value) {
@@ -212,11 +689,15 @@ func (e *encoder) fieldByIndex(v reflect.Value, index []int) (field reflect.Valu
 }
 
 func (e *encoder) structv(tag string, in reflect.Value) {
-	sinfo, err := getStructInfo(in.Type())
+	sinfo, err := getStructInfo(in.Type(), e.structOpts)
 	if err != nil {
 		panic(err)
 	}
+	ref := e.ref
 	e.mappingv(tag, func() {
+		if sinfo.MergeField >= 0 {
+			e.mergev(in.Field(sinfo.MergeField), ref)
+		}
 		for _, info := range sinfo.FieldsList {
 			var value reflect.Value
 			if info.Inline == nil {
@@ -230,37 +711,62 @@ func (e *encoder) structv(tag string, in reflect.Value) {
 			if info.OmitEmpty && isZero(value) {
 				continue
 			}
-			e.marshal("", reflect.ValueOf(info.Key))
+			k := reflect.ValueOf(info.Key)
+			e.marshalKey(k)
+			e.ref = refValue(ref, k)
 			e.flow = info.Flow
-			e.marshal("", value)
+			if info.String {
+				e.stringifiedv(value)
+			} else {
+				e.marshal("", value)
+			}
 		}
 		if sinfo.InlineMap >= 0 {
 			m := in.Field(sinfo.InlineMap)
 			if m.Len() > 0 {
 				e.flow = false
-				keys := keyList(m.MapKeys())
-				sort.Sort(keys)
+				keys := m.MapKeys()
+				sortKeys(keys, e.keyLess, ref)
 				for _, k := range keys {
 					if _, found := sinfo.FieldsMap[k.String()]; found {
 						panic(fmt.Sprintf("cannot have key %q in inlined map: conflicts with struct field", k.String()))
 					}
-					e.marshal("", k)
+					e.marshalKey(k)
+					e.ref = refValue(ref, k)
 					e.flow = false
 					e.marshal("", m.MapIndex(k))
 				}
 			}
 		}
 	})
+	e.ref = ref
+}
+
+// mergev emits the value of a ,merge field under a merge key ("<<"),
+// unless it is nil. The merge key always comes first in the mapping.
+func (e *encoder) mergev(value reflect.Value, ref *Node) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if value.IsNil() {
+			return
+		}
+	}
+	k := reflect.ValueOf("<<")
+	e.emitScalar("<<", "", "", yaml_PLAIN_SCALAR_STYLE, nil, nil, nil, nil)
+	e.ref = refValue(ref, k)
+	e.flow = false
+	e.marshal("", value)
 }
 
 func (e *encoder) mappingv(tag string, f func()) {
 	implicit := tag == ""
+	tag = e.explicitTag(tag, mapTag)
 	style := yaml_BLOCK_MAPPING_STYLE
 	if e.flow {
 		e.flow = false
 		style = yaml_FLOW_MAPPING_STYLE
 	}
-	yaml_mapping_start_event_initialize(&e.event, nil, []byte(tag), implicit, style)
+	yaml_mapping_start_event_initialize(&e.event, e.anchor(), []byte(tag), implicit, style)
 	e.emit()
 	f()
 	yaml_mapping_end_event_initialize(&e.event)
@@ -269,17 +775,21 @@ func (e *encoder) mappingv(tag string, f func()) {
 
 func (e *encoder) slicev(tag string, in reflect.Value) {
 	implicit := tag == ""
+	tag = e.explicitTag(tag, seqTag)
 	style := yaml_BLOCK_SEQUENCE_STYLE
 	if e.flow {
 		e.flow = false
 		style = yaml_FLOW_SEQUENCE_STYLE
 	}
-	e.must(yaml_sequence_start_event_initialize(&e.event, nil, []byte(tag), implicit, style))
+	e.must(yaml_sequence_start_event_initialize(&e.event, e.anchor(), []byte(tag), implicit, style))
 	e.emit()
+	ref := e.ref
 	n := in.Len()
 	for i := 0; i < n; i++ {
+		e.ref = refItem(ref, i)
 		e.marshal("", in.Index(i))
 	}
+	e.ref = ref
 	e.must(yaml_sequence_end_event_initialize(&e.event))
 	e.emit()
 }
@@ -347,17 +857,22 @@ func (e *encoder) stringv(tag string, in reflect.Value) {
 	// Note: it's possible for user code to emit invalid YAML
 	// if they explicitly specify a tag and a string containing
 	// text that's incompatible with that tag.
+	quote := e.quoteString(tag)
 	switch {
 	case strings.Contains(s, "\n"):
 		if e.flow {
 			style = yaml_DOUBLE_QUOTED_SCALAR_STYLE
+		} else if quote {
+			style = e.quotedStyle()
 		} else {
 			style = yaml_LITERAL_SCALAR_STYLE
 		}
-	case canUsePlain:
+	case canUsePlain && !quote:
 		style = yaml_PLAIN_SCALAR_STYLE
 	default:
-		style = yaml_DOUBLE_QUOTED_SCALAR_STYLE
+		// The emitter falls back to double quotes if single
+		// quotes cannot represent the string.
+		style = e.quotedStyle()
 	}
 	e.emitScalar(s, "", tag, style, nil, nil, nil, nil)
 }
@@ -389,6 +904,10 @@ func (e *encoder) timev(tag string, in reflect.Value) {
 }
 
 func (e *encoder) floatv(tag string, in reflect.Value) {
+	e.emitScalar(formatFloat(in), "", tag, yaml_PLAIN_SCALAR_STYLE, nil, nil, nil, nil)
+}
+
+func formatFloat(in reflect.Value) string {
 	// Issue #352: When formatting, use the precision of the underlying value
 	precision := 64
 	if in.Kind() == reflect.Float32 {
@@ -404,19 +923,66 @@ func (e *encoder) floatv(tag string, in reflect.Value) {
 	case "NaN":
 		s = ".nan"
 	}
-	e.emitScalar(s, "", tag, yaml_PLAIN_SCALAR_STYLE, nil, nil, nil, nil)
+	return s
+}
+
+// stringifiedv marshals the numeric or boolean value in as a string,
+// for fields with the json ,string option.
+func (e *encoder) stringifiedv(in reflect.Value) {
+	for in.Kind() == reflect.Ptr {
+		if in.IsNil() {
+			e.nilv()
+			return
+		}
+		in = in.Elem()
+	}
+	var s string
+	switch in.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s = strconv.FormatInt(in.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s = strconv.FormatUint(in.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		s = formatFloat(in)
+	case reflect.Bool:
+		s = strconv.FormatBool(in.Bool())
+	default:
+		e.marshal("", in)
+		return
+	}
+	e.stringv("", reflect.ValueOf(s))
 }
 
 func (e *encoder) nilv() {
 	e.emitScalar("null", "", "", yaml_PLAIN_SCALAR_STYLE, nil, nil, nil, nil)
 }
 
+// explicitTag returns the tag of a node which would otherwise be implicit,
+// resolving to rtag, as canonical output requires every tag to be explicit.
+func (e *encoder) explicitTag(tag, rtag string) string {
+	if tag == "" && e.emitter.canonical {
+		return longTag(rtag)
+	}
+	return tag
+}
+
 func (e *encoder) emitScalar(value, anchor, tag string, style yaml_scalar_style_t, head, line, foot, tail []byte) {
 	// TODO Kill this function. Replace all initialize calls by their underlining Go literals.
 	implicit := tag == ""
-	if !implicit {
+	if implicit && e.emitter.canonical {
+		// Plain scalars resolve to their tag, and quoted ones are strings.
+		rtag := strTag
+		if style == yaml_PLAIN_SCALAR_STYLE {
+			rtag, _ = resolve("", value)
+		}
+		tag = e.explicitTag(tag, rtag)
+	}
+	if tag != "" {
 		tag = longTag(tag)
 	}
+	if anchor == "" {
+		anchor = string(e.anchor())
+	}
 	e.must(yaml_scalar_event_initialize(&e.event, []byte(anchor), []byte(tag), []byte(value), implicit, implicit, style))
 	e.event.head_comment = head
 	e.event.line_comment = line
@@ -426,6 +992,8 @@ func (e *encoder) emitScalar(value, anchor, tag string, style yaml_scalar_style_
 }
 
 func (e *encoder) nodev(in reflect.Value) {
+	// Nodes define their own anchors.
+	e.hasPending = false
 	e.node(in.Interface().(*Node), "")
 }
 
@@ -470,22 +1038,18 @@ func (e *encoder) node(node *Node, tail string) {
 
 	switch node.Kind {
 	case DocumentNode:
-		yaml_document_start_event_initialize(&e.event, nil, nil, true)
-		e.event.head_comment = []byte(node.HeadComment)
-		e.emit()
+		e.startDocument(node)
 		for _, node := range node.Content {
 			e.node(node, "")
 		}
-		yaml_document_end_event_initialize(&e.event, true)
-		e.event.foot_comment = []byte(node.FootComment)
-		e.emit()
+		e.endDocument(node.FootComment)
 
 	case SequenceNode:
 		style := yaml_BLOCK_SEQUENCE_STYLE
 		if node.Style&FlowStyle != 0 {
 			style = yaml_FLOW_SEQUENCE_STYLE
 		}
-		e.must(yaml_sequence_start_event_initialize(&e.event, []byte(node.Anchor), []byte(longTag(tag)), tag == "", style))
+		e.must(yaml_sequence_start_event_initialize(&e.event, []byte(node.Anchor), []byte(longTag(e.explicitTag(tag, seqTag))), tag == "", style))
 		e.event.head_comment = []byte(node.HeadComment)
 		e.emit()
 		for _, node := range node.Content {
@@ -501,7 +1065,7 @@ func (e *encoder) node(node *Node, tail string) {
 		if node.Style&FlowStyle != 0 {
 			style = yaml_FLOW_MAPPING_STYLE
 		}
-		yaml_mapping_start_event_initialize(&e.event, []byte(node.Anchor), []byte(longTag(tag)), tag == "", style)
+		yaml_mapping_start_event_initialize(&e.event, []byte(node.Anchor), []byte(longTag(e.explicitTag(tag, mapTag))), tag == "", style)
 		e.event.tail_comment = []byte(tail)
 		e.event.head_comment = []byte(node.HeadComment)
 		e.emit()
@@ -554,6 +1118,7 @@ func (e *encoder) node(node *Node, tail string) {
 			value = encodeBase64(value)
 		}
 
+		quote := e.quoteString(tag) && node.ShortTag() == strTag
 		style := yaml_PLAIN_SCALAR_STYLE
 		switch {
 		case node.Style&DoubleQuotedStyle != 0:
@@ -564,10 +1129,10 @@ func (e *encoder) node(node *Node, tail string) {
 			style = yaml_LITERAL_SCALAR_STYLE
 		case node.Style&FoldedStyle != 0:
 			style = yaml_FOLDED_SCALAR_STYLE
-		case strings.Contains(value, "\n"):
+		case strings.Contains(value, "\n") && !quote:
 			style = yaml_LITERAL_SCALAR_STYLE
-		case forceQuoting:
-			style = yaml_DOUBLE_QUOTED_SCALAR_STYLE
+		case forceQuoting || quote:
+			style = e.quotedStyle()
 		}
 
 		e.emitScalar(value, node.Anchor, tag, style, []byte(node.HeadComment), []byte(node.LineComment), []byte(node.FootComment), []byte(tail))
@@ -575,3 +1140,75 @@ func (e *encoder) node(node *Node, tail string) {
 		failf("cannot encode node with unknown kind %d", node.Kind)
 	}
 }
+
+// canonicalNode drops the comments, directives, and presentation details
+// of the node and its children, normalizes their values, and sorts
+// mapping keys.
+func canonicalNode(n *Node) {
+	n.Document = nil
+	n.Style = 0
+	n.HeadComment = ""
+	n.LineComment = ""
+	n.FootComment = ""
+	n.Line = 0
+	n.Column = 0
+	switch n.Kind {
+	case ScalarNode:
+		canonicalValue(n)
+	case MappingNode:
+		pairs := make([][2]*Node, len(n.Content)/2)
+		for i := range pairs {
+			pairs[i] = [2]*Node{n.Content[2*i], n.Content[2*i+1]}
+		}
+		for _, pair := range pairs {
+			canonicalNode(pair[0])
+			canonicalNode(pair[1])
+		}
+		// Scalar keys come first ordered by tag and value, and other
+		// keys keep their relative order.
+		sort.SliceStable(pairs, func(i, j int) bool {
+			a, b := pairs[i][0], pairs[j][0]
+			if a.Kind != ScalarNode || b.Kind != ScalarNode {
+				return a.Kind == ScalarNode && b.Kind != ScalarNode
+			}
+			if atag, btag := a.ShortTag(), b.ShortTag(); atag != btag {
+				return atag < btag
+			}
+			return a.Value < b.Value
+		})
+		for i, pair := range pairs {
+			n.Content[2*i], n.Content[2*i+1] = pair[0], pair[1]
+		}
+	default:
+		for _, child := range n.Content {
+			canonicalNode(child)
+		}
+	}
+}
+
+// canonicalValue replaces the value of a null, boolean, integer or float
+// scalar node with the one the encoder would write for it, so that for
+// instance 0x10 and 16 become the same. Invalid values are left as is.
+func canonicalValue(n *Node) {
+	tag := n.ShortTag()
+	switch tag {
+	case nullTag, boolTag, intTag, floatTag:
+	default:
+		return
+	}
+	var v interface{}
+	if n.Decode(&v) != nil {
+		return
+	}
+	switch v := v.(type) {
+	case nil:
+		n.Value = "null"
+	case bool:
+		n.Value = strconv.FormatBool(v)
+	case float64:
+		n.Value = formatFloat(reflect.ValueOf(v))
+	default:
+		n.Value = fmt.Sprint(v)
+	}
+	n.Tag = tag
+}
@@ -25,6 +25,7 @@ import (
 
 	"net"
 	"os"
+	"reflect"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/yaml.v3"
@@ -458,6 +459,17 @@ var marshalTests = []struct {
 		"'foo'\n",
 	},
 
+	// Ordered maps.
+	{
+		yaml.MapSlice{{"b", 2}, {"a", 1}, {"c", yaml.MapSlice{{"z", 1}, {1, "y"}}}},
+		"b: 2\na: 1\nc:\n    z: 1\n    1: \"y\"\n",
+	}, {
+		&struct {
+			A yaml.MapSlice `yaml:"a,flow"`
+		}{yaml.MapSlice{{"b", 2}, {"a", 1}}},
+		"a: {b: 2, a: 1}\n",
+	},
+
 	// Enforced tagging with shorthand notation (issue #616).
 	{
 		&struct {
@@ -532,6 +544,90 @@ func (s *S) TestEncoderMultipleDocuments(c *C) {
 	c.Assert(buf.String(), Equals, "a: b\n---\nc: d\n")
 }
 
+func (s *S) TestEncoderDocumentMarkers(c *C) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.ExplicitDocumentStart(true)
+	enc.ExplicitDocumentEnd(true)
+	err := enc.Encode(map[string]string{"a": "b"})
+	c.Assert(err, Equals, nil)
+	err = enc.Encode(map[string]string{"c": "d"})
+	c.Assert(err, Equals, nil)
+	err = enc.Close()
+	c.Assert(err, Equals, nil)
+	c.Assert(buf.String(), Equals, "---\na: b\n...\n---\nc: d\n...\n")
+}
+
+func (s *S) TestEncoderDirectives(c *C) {
+	var node yaml.Node
+	err := yaml.Unmarshal([]byte("a: !<tag:example.com,2024:foo> b\n"), &node)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetVersionDirective("1.2")
+	enc.AddTagDirective("!e!", "tag:example.com,2024:")
+	err = enc.Encode(&node)
+	c.Assert(err, Equals, nil)
+	err = enc.Encode(map[string]string{"c": "d"})
+	c.Assert(err, Equals, nil)
+	err = enc.Close()
+	c.Assert(err, Equals, nil)
+	c.Assert(buf.String(), Equals, ""+
+		"%YAML 1.2\n"+
+		"%TAG !e! tag:example.com,2024:\n"+
+		"---\n"+
+		"a: !e!foo b\n"+
+		"...\n"+
+		"%YAML 1.2\n"+
+		"%TAG !e! tag:example.com,2024:\n"+
+		"---\n"+
+		"c: d\n")
+
+	dec := yaml.NewDecoder(&buf)
+	var first, second yaml.Node
+	c.Assert(dec.Decode(&first), IsNil)
+	c.Assert(dec.Decode(&second), IsNil)
+	c.Assert(first.Content[0].Content[1].Tag, Equals, "tag:example.com,2024:foo")
+	c.Assert(second.Content[0].Content[1].Value, Equals, "d")
+}
+
+func (s *S) TestEncoderDocumentDirectives(c *C) {
+	var node yaml.Node
+	err := yaml.Unmarshal([]byte("%YAML 1.1\n%TAG !e! tag:example.com,2024:\n---\n!e!a b\n"), &node)
+	c.Assert(err, IsNil)
+
+	// The directives of the document take precedence.
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetVersionDirective("1.2")
+	enc.AddTagDirective("!e!", "tag:example.org,2000:")
+	enc.AddTagDirective("!f!", "tag:example.org,2000:")
+	err = enc.Encode(&node)
+	c.Assert(err, Equals, nil)
+	err = enc.Close()
+	c.Assert(err, Equals, nil)
+	c.Assert(buf.String(), Equals, ""+
+		"%YAML 1.1\n"+
+		"%TAG !e! tag:example.com,2024:\n"+
+		"%TAG !f! tag:example.org,2000:\n"+
+		"---\n"+
+		"!e!a b\n")
+
+	node.Document.Version = "2.0"
+	_, err = yaml.Marshal(&node)
+	c.Assert(err, ErrorMatches, `yaml: unsupported YAML version "2.0"`)
+}
+
+func (s *S) TestEncoderInvalidDirectives(c *C) {
+	enc := yaml.NewEncoder(&bytes.Buffer{})
+	c.Assert(func() { enc.SetVersionDirective("2.0") }, PanicMatches, `yaml: unsupported YAML version "2.0"`)
+
+	enc.AddTagDirective("e", "tag:example.com,2024:")
+	err := enc.Encode(map[string]string{"a": "b"})
+	c.Assert(err, ErrorMatches, "yaml: tag handle must start with '!'")
+}
+
 func (s *S) TestEncoderWriteError(c *C) {
 	enc := yaml.NewEncoder(errorWriter{})
 	err := enc.Encode(map[string]string{"a": "b"})
@@ -560,6 +656,17 @@ var marshalErrorTests = []struct {
 		B map[string]int ",inline"
 	}{1, map[string]int{"a": 2}},
 	panic: `cannot have key "a" in inlined map: conflicts with struct field`,
+}, {
+	value: &struct {
+		A *MergeBase `yaml:",merge"`
+		B *MergeBase `yaml:",merge"`
+	}{},
+	panic: `multiple ,merge fields in struct struct \{ .*`,
+}, {
+	value: &struct {
+		A int `yaml:",merge"`
+	}{},
+	panic: `option ,merge needs a struct, a map, or a slice of them in struct struct \{ .*`,
 }}
 
 func (s *S) TestMarshalErrors(c *C) {
@@ -656,6 +763,716 @@ func (s *S) TestSetIndent(c *C) {
 	c.Assert(buf.String(), Equals, "a:\n        b:\n                c: d\n")
 }
 
+type namingStrategyT struct {
+	MaxRetries   int
+	HTTPServer   string
+	UserID       int
+	Field2       bool
+	Tagged       int `yaml:"Explicit"`
+	OmittedEmpty int `yaml:",omitempty"`
+}
+
+var namingStrategyTests = []struct {
+	naming yaml.NamingStrategy
+	data   string
+}{{
+	yaml.LowerCaseNaming,
+	"maxretries: 1\nhttpserver: a\nuserid: 2\nfield2: true\nExplicit: 3\nomittedempty: 4\n",
+}, {
+	yaml.SnakeCaseNaming,
+	"max_retries: 1\nhttp_server: a\nuser_id: 2\nfield2: true\nExplicit: 3\nomitted_empty: 4\n",
+}, {
+	yaml.KebabCaseNaming,
+	"max-retries: 1\nhttp-server: a\nuser-id: 2\nfield2: true\nExplicit: 3\nomitted-empty: 4\n",
+}, {
+	yaml.CamelCaseNaming,
+	"maxRetries: 1\nhttpServer: a\nuserID: 2\nfield2: true\nExplicit: 3\nomittedEmpty: 4\n",
+}}
+
+func (s *S) TestNamingStrategy(c *C) {
+	value := namingStrategyT{1, "a", 2, true, 3, 4}
+	for i, item := range namingStrategyTests {
+		c.Logf("test %d: %q", i, item.data)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetNamingStrategy(item.naming)
+		c.Assert(enc.Encode(value), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, item.data)
+
+		var decoded namingStrategyT
+		dec := yaml.NewDecoder(strings.NewReader(item.data))
+		dec.SetNamingStrategy(item.naming)
+		dec.KnownFields(true)
+		c.Assert(dec.Decode(&decoded), IsNil)
+		c.Assert(decoded, Equals, value)
+	}
+}
+
+type jsonTagsEmbedded struct {
+	Kind string `json:"kind"`
+}
+
+type jsonTagsT struct {
+	jsonTagsEmbedded
+	Name    string  `json:"name"`
+	Count   int     `json:"count,string"`
+	Enabled *bool   `json:"enabled,string,omitempty"`
+	Skipped string  `json:"-"`
+	Empty   string  `json:"empty,omitempty"`
+	Untyped int     `json:",omitempty"`
+	Both    float64 `json:"json" yaml:"yaml"`
+}
+
+func (s *S) TestJSONTags(c *C) {
+	enabled := true
+	value := jsonTagsT{
+		jsonTagsEmbedded: jsonTagsEmbedded{"k"},
+		Name:             "foo",
+		Count:            10,
+		Enabled:          &enabled,
+		Untyped:          1,
+		Both:             1.5,
+	}
+	data := "kind: k\nname: foo\ncount: \"10\"\nenabled: \"true\"\nuntyped: 1\nyaml: 1.5\n"
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.UseJSONTags(true)
+	c.Assert(enc.Encode(value), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, data)
+
+	var decoded jsonTagsT
+	dec := yaml.NewDecoder(strings.NewReader(data + "skipped: x\n"))
+	dec.UseJSONTags(true)
+	c.Assert(dec.Decode(&decoded), IsNil)
+	c.Assert(decoded, DeepEquals, value)
+
+	// Unquoted values are accepted for ,string fields as well.
+	decoded = jsonTagsT{}
+	dec = yaml.NewDecoder(strings.NewReader("count: 3\n"))
+	dec.UseJSONTags(true)
+	c.Assert(dec.Decode(&decoded), IsNil)
+	c.Assert(decoded.Count, Equals, 3)
+
+	// The json tags are ignored unless enabled.
+	out, err := yaml.Marshal(struct {
+		A int `json:"x"`
+		B int `json:"b" yaml:"c"`
+	}{1, 2})
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "a: 1\nc: 2\n")
+}
+
+var compactSequencesTests = []struct {
+	indent int
+	data   string
+}{{
+	indent: 2,
+	data: "" +
+		"a:\n" +
+		"# head\n" +
+		"- x\n" +
+		"- - y\n" +
+		"  - z\n" +
+		"- b: 1\n" +
+		"  c:\n" +
+		"  - d\n" +
+		"  - e # line\n" +
+		"- [f, g]\n" +
+		"h:\n" +
+		"  i:\n" +
+		"  - j\n",
+}, {
+	indent: 4,
+	data: "" +
+		"a:\n" +
+		"- x\n" +
+		"- b: 1\n" +
+		"  c:\n" +
+		"  - d\n" +
+		"h:\n" +
+		"    i:\n" +
+		"    - j\n",
+}}
+
+func (s *S) TestCompactSequences(c *C) {
+	for i, item := range compactSequencesTests {
+		c.Logf("test %d: %q", i, item.data)
+		var node yaml.Node
+		c.Assert(yaml.Unmarshal([]byte(item.data), &node), IsNil)
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(item.indent)
+		enc.CompactSequences(true)
+		c.Assert(enc.Encode(&node), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, item.data)
+
+		// Values are the same when encoded in the indented form.
+		var value, compact interface{}
+		c.Assert(node.Decode(&value), IsNil)
+		data, err := yaml.Marshal(value)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Not(Equals), item.data)
+		c.Assert(yaml.Unmarshal(data, &compact), IsNil)
+		c.Assert(compact, DeepEquals, value)
+	}
+}
+
+var quoteStyleTests = []struct {
+	style yaml.Style
+	all   bool
+	value interface{}
+	data  string
+}{{
+	style: yaml.SingleQuotedStyle,
+	value: map[string]interface{}{"a": "true", "b": "1.10", "c": "it's", "d": "plain", "e": "x\x01"},
+	data:  "a: 'true'\nb: '1.10'\nc: it's\nd: plain\ne: \"x\\x01\"\n",
+}, {
+	style: yaml.DoubleQuotedStyle,
+	all:   true,
+	value: map[string]interface{}{"a": "true", "b": 1, "c": "it's", "d": "plain\ntext"},
+	data:  "\"a\": \"true\"\n\"b\": 1\n\"c\": \"it's\"\n\"d\": \"plain\\ntext\"\n",
+}, {
+	style: yaml.SingleQuotedStyle,
+	all:   true,
+	value: map[string]interface{}{"a": []string{"b", "it's"}, "true": nil},
+	data:  "'a':\n    - 'b'\n    - 'it''s'\n'true': null\n",
+}, {
+	style: yaml.DoubleQuotedStyle,
+	all:   true,
+	value: &struct {
+		A string
+		B map[int]string
+	}{"x", map[int]string{1: "y"}},
+	data: "\"a\": \"x\"\n\"b\":\n    1: \"y\"\n",
+}, {
+	style: yaml.SingleQuotedStyle,
+	all:   true,
+	value: &yaml.Node{
+		Kind: yaml.MappingNode,
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "a"},
+			{Kind: yaml.ScalarNode, Value: "b"},
+			{Kind: yaml.ScalarNode, Value: "c"},
+			{Kind: yaml.ScalarNode, Value: "1"},
+			{Kind: yaml.ScalarNode, Value: "d"},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "2"},
+		},
+	},
+	data: "'a': 'b'\n'c': 1\n'd': '2'\n",
+}}
+
+func (s *S) TestQuoteStyle(c *C) {
+	for i, item := range quoteStyleTests {
+		c.Logf("test %d: %q", i, item.data)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetQuoteStyle(item.style)
+		enc.QuoteStrings(item.all)
+		c.Assert(enc.Encode(item.value), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, item.data)
+
+		// The output decodes to the same values as the default encoding.
+		if _, ok := item.value.(*yaml.Node); ok {
+			continue
+		}
+		data, err := yaml.Marshal(item.value)
+		c.Assert(err, IsNil)
+		var value, quoted interface{}
+		c.Assert(yaml.Unmarshal(data, &value), IsNil)
+		c.Assert(yaml.Unmarshal(buf.Bytes(), &quoted), IsNil)
+		c.Assert(quoted, DeepEquals, value)
+	}
+}
+
+func (s *S) TestQuoteStyleInvalid(c *C) {
+	enc := yaml.NewEncoder(&bytes.Buffer{})
+	c.Assert(func() { enc.SetQuoteStyle(yaml.LiteralStyle) }, PanicMatches, "yaml: quote style must be DoubleQuotedStyle or SingleQuotedStyle")
+}
+
+func (s *S) TestSetCanonical(c *C) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	enc.SetCanonical(true)
+	err := enc.Encode(map[string]interface{}{"a": 1, "b": []interface{}{"x", nil, "1"}})
+	c.Assert(err, IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"---\n"+
+		"!!map {\n"+
+		"  ? !!str \"a\"\n"+
+		"  : !!int \"1\",\n"+
+		"  ? !!str \"b\"\n"+
+		"  : !!seq [\n"+
+		"    !!str \"x\",\n"+
+		"    !!null \"null\",\n"+
+		"    !!str \"1\",\n"+
+		"  ],\n"+
+		"}\n")
+
+	var value interface{}
+	c.Assert(yaml.Unmarshal(buf.Bytes(), &value), IsNil)
+	c.Assert(value, DeepEquals, map[string]interface{}{"a": 1, "b": []interface{}{"x", nil, "1"}})
+}
+
+var canonicalizeTests = [][]string{{
+	"a: 1\nb: [x, ~, 0x10, 1.50, !!float 2, TRUE]\n",
+	"# comment\nb:\n- 'x'\n- null\n- 16\n- 1.5\n- !!float 2.0\n- true\na: +1\n",
+	"{b: [\"x\", !!null '', 0o20, 15e-1, !!float '2', True], \"a\": 0x1}",
+}, {
+	"z: &x {q: 1}\na: *x\n",
+	"a: &x\n  q: 1\nz: *x\n",
+	"a: {q: 1}\nz: {q: 1}\n",
+}, {
+	"a: &x 1\nb: *x\n",
+	"a: 1\nb: 1\n",
+	"b: 1\na: &y 1\n",
+}, {
+	"a: &b {x: 1}\nc: {<<: *b, y: 2}\n",
+	"c: {y: 2, x: 1}\na: {x: 1}\n",
+}, {
+	"foo\n---\n!e bar\n",
+	"--- \"foo\"\n--- !e 'bar'\n...\n",
+}, {
+	"--- !<tag:example.com,2024:x> a\n",
+	"%TAG !e! tag:example.com,2024:\n--- !e!x a\n",
+}, {
+	"a",
+	"%YAML 1.1\n--- a",
+}}
+
+func (s *S) TestCanonicalize(c *C) {
+	for i, inputs := range canonicalizeTests {
+		c.Logf("test %d: %q", i, inputs)
+		want, err := yaml.Canonicalize([]byte(inputs[0]))
+		c.Assert(err, IsNil)
+		for _, in := range inputs[1:] {
+			out, err := yaml.Canonicalize([]byte(in))
+			c.Assert(err, IsNil)
+			c.Assert(string(out), Equals, string(want))
+		}
+
+		// The canonical form decodes to the same values.
+		dec := yaml.NewDecoder(bytes.NewReader([]byte(inputs[0])))
+		canonical := yaml.NewDecoder(bytes.NewReader(want))
+		for {
+			var value, result interface{}
+			err := dec.Decode(&value)
+			c.Assert(canonical.Decode(&result), Equals, err)
+			if err != nil {
+				break
+			}
+			c.Assert(result, DeepEquals, value)
+		}
+	}
+
+	out, err := yaml.Canonicalize(nil)
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "")
+
+	_, err = yaml.Canonicalize([]byte("a: [\n"))
+	c.Assert(err, ErrorMatches, "yaml: line 1: did not find expected node content")
+}
+
+var lineBreakTests = []struct {
+	lineBreak yaml.LineBreak
+	data      string
+}{{
+	lineBreak: yaml.LFBreak,
+	data:      "a: |\n    x\n    y\nb:\n    - 1\n---\nc\n",
+}, {
+	lineBreak: yaml.CRLFBreak,
+	data:      "a: |\r\n    x\r\n    y\r\nb:\r\n    - 1\r\n---\r\nc\r\n",
+}, {
+	lineBreak: yaml.CRBreak,
+	data:      "a: |\r    x\r    y\rb:\r    - 1\r---\rc\r",
+}}
+
+func (s *S) TestSetLineBreak(c *C) {
+	for i, item := range lineBreakTests {
+		c.Logf("test %d: %q", i, item.data)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetLineBreak(item.lineBreak)
+		c.Assert(enc.Encode(map[string]interface{}{"a": "x\ny\n", "b": []int{1}}), IsNil)
+		c.Assert(enc.Encode("c"), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, item.data)
+
+		// The line break style is found when decoding, but only
+		// recorded when it isn't the default.
+		var node yaml.Node
+		c.Assert(yaml.Unmarshal(buf.Bytes(), &node), IsNil)
+		if item.lineBreak == yaml.LFBreak {
+			c.Assert(node.Document, IsNil)
+		} else {
+			c.Assert(node.Document.LineBreak, Equals, item.lineBreak)
+		}
+	}
+	enc := yaml.NewEncoder(&bytes.Buffer{})
+	c.Assert(func() { enc.SetLineBreak(0) }, PanicMatches, "yaml: unknown line break style")
+}
+
+func (s *S) TestPreserveLineBreak(c *C) {
+	for i, item := range lineBreakTests {
+		c.Logf("test %d: %q", i, item.data)
+		dec := yaml.NewDecoder(strings.NewReader(item.data))
+		var first, second yaml.Node
+		c.Assert(dec.Decode(&first), IsNil)
+		c.Assert(dec.Decode(&second), IsNil)
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.PreserveLineBreak(true)
+		c.Assert(enc.Encode(&first), IsNil)
+		c.Assert(enc.Encode(&second), IsNil)
+		c.Assert(enc.Encode(map[string]int{"d": 1}), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, item.data+"---\nd: 1\n")
+	}
+}
+
+var encodingTests = []struct {
+	encoding yaml.Encoding
+	bom      bool
+	data     []byte
+}{{
+	encoding: yaml.UTF8Encoding,
+	data:     []byte("a: é\n"),
+}, {
+	encoding: yaml.UTF8Encoding,
+	bom:      true,
+	data:     []byte("\xef\xbb\xbfa: é\n"),
+}, {
+	encoding: yaml.UTF16LEEncoding,
+	data:     []byte("\xff\xfea\x00:\x00 \x00\xe9\x00\n\x00"),
+}, {
+	encoding: yaml.UTF16BEEncoding,
+	data:     []byte("\xfe\xff\x00a\x00:\x00 \x00\xe9\x00\n"),
+}}
+
+func (s *S) TestSetEncoding(c *C) {
+	for i, item := range encodingTests {
+		c.Logf("test %d: %q", i, item.data)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetEncoding(item.encoding)
+		enc.WriteBOM(item.bom)
+		c.Assert(enc.Encode(map[string]string{"a": "é"}), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.Bytes(), DeepEquals, item.data)
+	}
+}
+
+func (s *S) TestSetEncodingRoundtrip(c *C) {
+	// Large enough to be flushed several times, with characters
+	// outside the basic plane written as surrogate pairs.
+	value := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		value[fmt.Sprintf("k%d", i)] = "é€"
+	}
+	node := &yaml.Node{}
+	c.Assert(node.Encode(value), IsNil)
+	node.Content[1].LineComment = "# \U0001F600"
+	for _, encoding := range []yaml.Encoding{yaml.UTF16LEEncoding, yaml.UTF16BEEncoding} {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetEncoding(encoding)
+		c.Assert(enc.Encode(node), IsNil)
+		c.Assert(enc.Close(), IsNil)
+
+		var result yaml.Node
+		c.Assert(yaml.Unmarshal(buf.Bytes(), &result), IsNil)
+		c.Assert(result.Content[0].Content[1].LineComment, Equals, node.Content[1].LineComment)
+		var decoded map[string]string
+		c.Assert(result.Decode(&decoded), IsNil)
+		c.Assert(decoded, DeepEquals, value)
+	}
+}
+
+func (s *S) TestASCIIOnly(c *C) {
+	var node yaml.Node
+	err := yaml.Unmarshal([]byte(""+
+		"\u00e9: '\u00fc x'\n"+
+		"b: |\n  line \u00e9\n  two\n"+
+		"c: [\u00f6, plain, \"x\\x01\"]\n"+
+		"d: \U0001F600 # comment \u00f6\n"), &node)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.ASCIIOnly(true)
+	c.Assert(enc.Encode(&node), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"\"\\u00E9\": \"\\u00FC x\"\n"+
+		"b: \"line \\u00E9\\ntwo\\n\"\n"+
+		"c: [\"\\u00F6\", plain, \"x\\x01\"]\n"+
+		"d: \"\\U0001F600\" # comment \\u00F6\n")
+
+	var value, result interface{}
+	c.Assert(node.Decode(&value), IsNil)
+	c.Assert(yaml.Unmarshal(buf.Bytes(), &result), IsNil)
+	c.Assert(result, DeepEquals, value)
+}
+
+type sharedNode struct {
+	Name string
+	Next *sharedNode    `yaml:",omitempty"`
+	Tags []string       `yaml:",omitempty"`
+	Refs map[string]int `yaml:",omitempty"`
+}
+
+func (s *S) TestAliasSharedValues(c *C) {
+	shared := &sharedNode{Name: "s", Tags: []string{"x"}}
+	refs := map[string]int{"a": 1}
+	tags := []string{"p", "q"}
+	value := map[string]interface{}{
+		"a": shared,
+		"b": shared,
+		"c": []*sharedNode{shared, {Name: "t", Tags: tags, Refs: refs}},
+		"d": tags,
+		"e": refs,
+		"f": tags[:1],
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.AliasSharedValues(true)
+	c.Assert(enc.Encode(value), IsNil)
+	c.Assert(enc.Encode(value), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	doc := "" +
+		"a: &id001\n" +
+		"    name: s\n" +
+		"    tags:\n" +
+		"        - x\n" +
+		"b: *id001\n" +
+		"c:\n" +
+		"    - *id001\n" +
+		"    - name: t\n" +
+		"      tags: &id002\n" +
+		"        - p\n" +
+		"        - q\n" +
+		"      refs: &id003\n" +
+		"        a: 1\n" +
+		"d: *id002\n" +
+		"e: *id003\n" +
+		"f:\n" +
+		"    - p\n"
+	c.Assert(buf.String(), Equals, doc+"---\n"+doc)
+
+	// The aliased values decode as the original ones.
+	var result, expected interface{}
+	c.Assert(yaml.Unmarshal(buf.Bytes(), &result), IsNil)
+	data, err := yaml.Marshal(value)
+	c.Assert(err, IsNil)
+	c.Assert(yaml.Unmarshal(data, &expected), IsNil)
+	c.Assert(result, DeepEquals, expected)
+}
+
+func (s *S) TestAliasSharedValuesCycle(c *C) {
+	cyclic := &sharedNode{Name: "a", Next: &sharedNode{Name: "b"}}
+	cyclic.Next.Next = cyclic
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.AliasSharedValues(true)
+	c.Assert(enc.Encode(cyclic), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "&id001\nname: a\nnext:\n    name: b\n    next: *id001\n")
+
+	var node yaml.Node
+	c.Assert(yaml.Unmarshal(buf.Bytes(), &node), IsNil)
+	c.Assert(node.Content[0].Content[3].Content[3].Alias, Equals, node.Content[0])
+
+	_, err := yaml.Marshal(cyclic)
+	c.Assert(err, ErrorMatches, `yaml: encountered a cycle via \*yaml_test.sharedNode at next.next`)
+}
+
+func (s *S) TestMarshalCycle(c *C) {
+	cyclic := &sharedNode{Name: "a", Next: &sharedNode{Name: "b"}}
+	cyclic.Next.Next = cyclic
+	_, err := yaml.Marshal(cyclic)
+	c.Assert(err, ErrorMatches, `yaml: encountered a cycle via \*yaml_test.sharedNode at next.next`)
+	uerr, ok := err.(*yaml.UnsupportedValueError)
+	c.Assert(ok, Equals, true)
+	c.Assert(uerr.Path, Equals, "next.next")
+	c.Assert(uerr.Value.Type(), Equals, reflect.TypeOf(cyclic))
+
+	cyclicMap := map[string]interface{}{}
+	cyclicMap["a"] = []interface{}{1, map[string]interface{}{"b": cyclicMap}}
+	_, err = yaml.Marshal(cyclicMap)
+	c.Assert(err, ErrorMatches, `yaml: encountered a cycle via \[\]interface \{\} at \[1\].b.a`)
+
+	cyclicSlice := []interface{}{nil}
+	cyclicSlice[0] = cyclicSlice
+	_, err = yaml.Marshal(cyclicSlice)
+	c.Assert(err, ErrorMatches, `yaml: encountered a cycle via \[\]interface \{\} at \[0\]`)
+
+	// Deep values which aren't cyclic are fine.
+	var deep *sharedNode
+	for i := 0; i < 1500; i++ {
+		deep = &sharedNode{Name: "n", Next: deep}
+	}
+	_, err = yaml.Marshal(deep)
+	c.Assert(err, IsNil)
+}
+
+var setWidthTests = []struct {
+	indent, width int
+	data          string
+}{{
+	indent: 4, width: -1,
+	data: "a:\n    b: one two three four five six\n    c: \"one two three four five six\\x01\"\n    d: >-\n        one two three four five six\n",
+}, {
+	indent: 2, width: 20,
+	data: "a:\n  b: one two three four\n    five six\n  c: \"one two three four\n    five six\\x01\"\n  d: >-\n    one two three four\n    five six\n",
+}, {
+	indent: 4, width: 20,
+	data: "a:\n    b: one two three four\n        five six\n    c: \"one two three\n        four five six\\x01\"\n    d: >-\n        one two three\n        four five six\n",
+}, {
+	indent: 4, width: 12,
+	data: "a:\n    b: one two\n        three\n        four five\n        six\n    c: \"one two\n        three\n        four five\n        six\\x01\"\n    d: >-\n        one two\n        three\n        four five\n        six\n",
+}, {
+	// Too narrow for the indentation, so 80 is used instead.
+	indent: 8, width: 12,
+	data: "a:\n        b: one two three four five six\n        c: \"one two three four five six\\x01\"\n        d: >-\n                one two three four five six\n",
+}, {
+	indent: 4, width: 0,
+	data: "a:\n    b: one two three four five six\n    c: \"one two three four five six\\x01\"\n    d: >-\n        one two three four five six\n",
+}}
+
+func (s *S) TestSetWidthZero(c *C) {
+	// A width of 0 is too narrow, so 80 is used instead.
+	value := map[string]string{"a": strings.Repeat("word ", 40)}
+	var out []string
+	for _, width := range []int{0, 80} {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetWidth(width)
+		c.Assert(enc.Encode(value), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		out = append(out, buf.String())
+	}
+	c.Assert(strings.Count(out[0], "\n") > 1, Equals, true)
+	c.Assert(out[0], Equals, out[1])
+}
+
+func (s *S) TestSetWidth(c *C) {
+	value := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": "one two three four five six",
+			"c": "one two three four five six\x01",
+			"d": &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.FoldedStyle, Value: "one two three four five six"},
+		},
+	}
+	for i, item := range setWidthTests {
+		c.Logf("test %d: indent %d, width %d", i, item.indent, item.width)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(item.indent)
+		enc.SetWidth(item.width)
+		c.Assert(enc.Encode(value), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, item.data)
+
+		var decoded interface{}
+		c.Assert(yaml.Unmarshal(buf.Bytes(), &decoded), IsNil)
+		c.Assert(decoded.(map[string]interface{})["a"].(map[string]interface{})["c"], Equals, "one two three four five six\x01")
+	}
+}
+
+func (s *S) TestSetKeyOrder(c *C) {
+	value := map[interface{}]int{"a10": 1, "a2": 2, "B": 3, 1: 4, 10: 5, 2: 6}
+	tests := []struct {
+		less func(a, b reflect.Value) bool
+		data string
+	}{{
+		nil,
+		"1: 4\n2: 6\n10: 5\nB: 3\na2: 2\na10: 1\n",
+	}, {
+		yaml.NaturalKeyOrder,
+		"1: 4\n2: 6\n10: 5\nB: 3\na2: 2\na10: 1\n",
+	}, {
+		yaml.LexicalKeyOrder,
+		"1: 4\n10: 5\n2: 6\nB: 3\na10: 1\na2: 2\n",
+	}, {
+		func(a, b reflect.Value) bool { return yaml.LexicalKeyOrder(b, a) },
+		"a2: 2\na10: 1\nB: 3\n2: 6\n10: 5\n1: 4\n",
+	}}
+	for i, item := range tests {
+		c.Logf("test %d: %q", i, item.data)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetKeyOrder(item.less)
+		c.Assert(enc.Encode(value), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, item.data)
+	}
+}
+
+func (s *S) TestPreserveKeyOrder(c *C) {
+	data := "" +
+		"zeta: 1\n" +
+		"alpha:\n" +
+		"    - {q: 1, p: 2}\n" +
+		"    - {b: 1, a: 2}\n" +
+		"inner:\n" +
+		"    d: 1\n" +
+		"    c: 2\n" +
+		"other: &other\n" +
+		"    f: 1\n" +
+		"    e: 2\n" +
+		"alias: *other\n"
+	var doc yaml.Node
+	c.Assert(yaml.Unmarshal([]byte(data), &doc), IsNil)
+
+	var value struct {
+		Zeta  int
+		Alpha []map[string]int
+		Inner map[string]int
+		Other map[string]int
+		Alias map[string]int
+	}
+	c.Assert(doc.Decode(&value), IsNil)
+	value.Alpha[0]["new"] = 3
+	value.Inner["b"] = 3
+	value.Inner["a"] = 4
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(4)
+	enc.PreserveKeyOrder(&doc)
+	c.Assert(enc.Encode(value), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"zeta: 1\n"+
+		"alpha:\n"+
+		"    - q: 1\n"+
+		"      p: 2\n"+
+		"      new: 3\n"+
+		"    - b: 1\n"+
+		"      a: 2\n"+
+		"inner:\n"+
+		"    d: 1\n"+
+		"    c: 2\n"+
+		"    a: 4\n"+
+		"    b: 3\n"+
+		"other:\n"+
+		"    f: 1\n"+
+		"    e: 2\n"+
+		"alias:\n"+
+		"    f: 1\n"+
+		"    e: 2\n")
+}
+
 func (s *S) TestSortedOutput(c *C) {
 	order := []interface{}{
 		false,
@@ -734,3 +1551,185 @@ func (s *S) TestSortedOutput(c *C) {
 func newTime(t time.Time) *time.Time {
 	return &t
 }
+
+type MergeBase struct {
+	Image string
+	Tags  []string `yaml:",omitempty"`
+}
+
+type mergeService struct {
+	*MergeBase `yaml:",merge"`
+	Name       string
+}
+
+func (s *S) TestMarshalMerge(c *C) {
+	base := &MergeBase{Image: "app"}
+	value := map[string]interface{}{
+		"base": base,
+		"services": []mergeService{
+			{MergeBase: base, Name: "a"},
+			{MergeBase: base, Name: "b"},
+			{Name: "c"},
+		},
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.AliasSharedValues(true)
+	c.Assert(enc.Encode(value), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"base: &id001\n"+
+		"    image: app\n"+
+		"services:\n"+
+		"    - <<: *id001\n"+
+		"      name: a\n"+
+		"    - <<: *id001\n"+
+		"      name: b\n"+
+		"    - name: c\n")
+
+	// Without aliases the merged mapping is written out in place.
+	data, err := yaml.Marshal(mergeService{MergeBase: base, Name: "a"})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "<<:\n    image: app\nname: a\n")
+
+	// The merge key is resolved when decoding into other types.
+	var m map[string]interface{}
+	c.Assert(yaml.Unmarshal(data, &m), IsNil)
+	c.Assert(m, DeepEquals, map[string]interface{}{"image": "app", "name": "a"})
+}
+
+// textLevel sorts differently as a number and as text.
+type textLevel int
+
+var textLevels = []string{"low", "medium", "high"}
+
+func (l textLevel) MarshalText() ([]byte, error) {
+	if l < 0 || int(l) >= len(textLevels) {
+		return nil, fmt.Errorf("unknown level %d", l)
+	}
+	return []byte(textLevels[l]), nil
+}
+
+func (l *textLevel) UnmarshalText(text []byte) error {
+	for i, s := range textLevels {
+		if s == string(text) {
+			*l = textLevel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown level %q", text)
+}
+
+// textPoint implements its text methods on the pointer only.
+type textPoint struct {
+	X, Y int
+}
+
+func (p *textPoint) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d,%d", p.X, p.Y)), nil
+}
+
+func (p *textPoint) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d,%d", &p.X, &p.Y)
+	return err
+}
+
+func (s *S) TestMarshalTextKeys(c *C) {
+	levels := map[textLevel]int{0: 1, 1: 2, 2: 3}
+	data, err := yaml.Marshal(levels)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "high: 3\nlow: 1\nmedium: 2\n")
+	var levels2 map[textLevel]int
+	c.Assert(yaml.Unmarshal(data, &levels2), IsNil)
+	c.Assert(levels2, DeepEquals, levels)
+
+	points := map[textPoint]string{{10, 2}: "a", {9, 1}: "b", {1, 30}: "c"}
+	data, err = yaml.Marshal(points)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "1,30: c\n9,1: b\n10,2: a\n")
+	var points2 map[textPoint]string
+	c.Assert(yaml.Unmarshal(data, &points2), IsNil)
+	c.Assert(points2, DeepEquals, points)
+
+	// The lexical order also follows the text of the keys.
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetKeyOrder(yaml.LexicalKeyOrder)
+	c.Assert(enc.Encode(points), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "1,30: c\n10,2: a\n9,1: b\n")
+
+	// Failing to marshal a key fails the encoding, whatever the order.
+	_, err = yaml.Marshal(map[textLevel]int{0: 1, 7: 2})
+	c.Assert(err, ErrorMatches, "unknown level 7")
+	buf.Reset()
+	enc = yaml.NewEncoder(&buf)
+	enc.SetKeyOrder(yaml.LexicalKeyOrder)
+	c.Assert(enc.Encode(map[textLevel]int{0: 1, 7: 2}), ErrorMatches, "unknown level 7")
+
+	// Keys are decoded from their text even when it resolves to null.
+	err = yaml.Unmarshal([]byte("~: 1\n"), &levels2)
+	c.Assert(err, ErrorMatches, `unknown level "~"`)
+}
+
+func (s *S) TestEncodeSets(c *C) {
+	value := map[string]interface{}{
+		"block": map[string]struct{}{"b": {}, "a": {}},
+		"empty": map[int]struct{}{},
+		"flow": struct {
+			Set map[int]struct{} `yaml:",flow"`
+		}{map[int]struct{}{2: {}, 1: {}}},
+	}
+
+	// Sets are plain mappings by default.
+	data, err := yaml.Marshal(value["block"])
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "a: {}\nb: {}\n")
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.EncodeSets(true)
+	c.Assert(enc.Encode(value), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"block: !!set\n"+
+		"    ? a\n"+
+		"    ? b\n"+
+		"empty: !!set {}\n"+
+		"flow:\n"+
+		"    set: !!set {1, 2}\n")
+
+	var result struct {
+		Block map[string]struct{}
+		Empty map[int]struct{}
+		Flow  struct{ Set map[int]bool }
+	}
+	c.Assert(yaml.Unmarshal(buf.Bytes(), &result), IsNil)
+	c.Assert(result.Block, DeepEquals, value["block"])
+	c.Assert(result.Empty, DeepEquals, value["empty"])
+	c.Assert(result.Flow.Set, DeepEquals, map[int]bool{1: true, 2: true})
+
+	// Set nodes are written the same way.
+	doc := "!!set\n? a\n? b\n"
+	var node yaml.Node
+	c.Assert(yaml.Unmarshal([]byte(doc), &node), IsNil)
+	data, err = yaml.Marshal(&node)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, doc)
+	c.Assert(yaml.Unmarshal([]byte("!!set\n? a\n: null\n? b\n: ~\n"), &node), IsNil)
+	data, err = yaml.Marshal(&node)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, doc)
+
+	// The canonical form doesn't depend on where the set comes from.
+	buf.Reset()
+	enc = yaml.NewEncoder(&buf)
+	enc.EncodeSets(true)
+	enc.SetCanonical(true)
+	c.Assert(enc.Encode(value["block"]), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	canonical, err := yaml.Canonicalize([]byte(doc))
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, string(canonical))
+}
@@ -2550,6 +2550,43 @@ var nodeTests = []struct {
 				},
 			}},
 		},
+	}, {
+		"%YAML 1.2\n%TAG !e! tag:example.com,2024:\n---\n!e!foo bar\n",
+		yaml.Node{
+			Kind: yaml.DocumentNode,
+			Document: &yaml.DocumentInfo{
+				Version: "1.2",
+				TagDirectives: []yaml.TagDirective{{
+					Handle: "!e!",
+					Prefix: "tag:example.com,2024:",
+				}},
+			},
+			Line:   1,
+			Column: 1,
+			Content: []*yaml.Node{{
+				Kind:   yaml.ScalarNode,
+				Style:  yaml.TaggedStyle,
+				Value:  "bar",
+				Tag:    "tag:example.com,2024:foo",
+				Line:   4,
+				Column: 1,
+			}},
+		},
+	}, {
+		"%YAML 1.1\n---\nfoo\n",
+		yaml.Node{
+			Kind:     yaml.DocumentNode,
+			Document: &yaml.DocumentInfo{Version: "1.1"},
+			Line:     1,
+			Column:   1,
+			Content: []*yaml.Node{{
+				Kind:   yaml.ScalarNode,
+				Value:  "foo",
+				Tag:    "!!str",
+				Line:   3,
+				Column: 1,
+			}},
+		},
 	},
 }
 
@@ -2884,3 +2921,163 @@ func fprintCommentSet(out io.Writer, node *yaml.Node) {
 		fmt.Fprintf(out, "%q / %q / %q", node.HeadComment, node.LineComment, node.FootComment)
 	}
 }
+
+var expandAliasesTests = []struct {
+	input, output string
+}{{
+	"a: &x 1\nb: *x\n",
+	"a: 1\nb: 1\n",
+}, {
+	"a: &x {c: 1, d: [2, 3]}\nb: *x # comment\n",
+	"a: {c: 1, d: [2, 3]}\nb: {c: 1, d: [2, 3]} # comment\n",
+}, {
+	"base: &base\n    a: 1\n    b: 2\nchild:\n    <<: *base\n    b: 3\n",
+	"base:\n    a: 1\n    b: 2\nchild:\n    a: 1\n    b: 3\n",
+}, {
+	"a: &a {x: 1, y: 1}\nb: &b {x: 2, z: 2}\nc:\n    <<: [*a, *b]\n    w: 3\n",
+	"a: {x: 1, y: 1}\nb: {x: 2, z: 2}\nc:\n    x: 1\n    y: 1\n    z: 2\n    w: 3\n",
+}, {
+	"a:\n    <<: {b: {<<: {c: 1}}}\n",
+	"a:\n    b: {c: 1}\n",
+}}
+
+func (s *S) TestNodeExpandAliases(c *C) {
+	for i, item := range expandAliasesTests {
+		c.Logf("test %d: %q", i, item.input)
+		var node yaml.Node
+		err := yaml.Unmarshal([]byte(item.input), &node)
+		c.Assert(err, IsNil)
+		before, err := yaml.Marshal(&node)
+		c.Assert(err, IsNil)
+		expanded, err := node.ExpandAliases()
+		c.Assert(err, IsNil)
+		data, err := yaml.Marshal(expanded)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, item.output)
+
+		// The original node is untouched.
+		data, err = yaml.Marshal(&node)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, string(before))
+	}
+}
+
+var expandAliasesErrorTests = []struct {
+	input, error string
+}{{
+	"a: &a [1, *a]\n",
+	"yaml: anchor 'a' value contains itself",
+}, {
+	"a: &a 1\nb: {<<: *a}\n",
+	"yaml: map merge requires map or sequence of maps as the value",
+}, {
+	"a: {<<: [{b: 1}, 2]}\n",
+	"yaml: map merge requires map or sequence of maps as the value",
+}, {
+	"a: &a [1, 1, 1, 1, 1, 1, 1, 1, 1, 1]\n" +
+		"b: &b [*a, *a, *a, *a, *a, *a, *a, *a, *a, *a]\n" +
+		"c: &c [*b, *b, *b, *b, *b, *b, *b, *b, *b, *b]\n" +
+		"d: &d [*c, *c, *c, *c, *c, *c, *c, *c, *c, *c]\n" +
+		"e: [*d, *d, *d, *d, *d, *d, *d, *d, *d, *d]\n",
+	"yaml: document contains excessive aliasing",
+}}
+
+func (s *S) TestNodeExpandAliasesErrors(c *C) {
+	for i, item := range expandAliasesErrorTests {
+		c.Logf("test %d: %q", i, item.input)
+		var node yaml.Node
+		err := yaml.Unmarshal([]byte(item.input), &node)
+		c.Assert(err, IsNil)
+		_, err = node.ExpandAliases()
+		c.Assert(err, ErrorMatches, item.error)
+	}
+}
+
+var deduplicateTests = []struct {
+	input, output string
+}{{
+	"a: {b: 1, c: 2}\nd: {b: 1, c: 2}\ne: {b: 1, c: 2}\n",
+	"a: &id001 {b: 1, c: 2}\nd: *id001\ne: *id001\n",
+}, {
+	// Small subtrees and scalars are left alone.
+	"a: {b: 1}\nc: {b: 1}\nd: long value\ne: long value\n",
+	"a: {b: 1}\nc: {b: 1}\nd: long value\ne: long value\n",
+}, {
+	// Existing anchors are reused and not clobbered.
+	"a: &id001 {b: 1, c: 2}\nd: {b: 1, c: 2}\ne: *id001\nf: [1, 2, 3, 4]\ng: [1, 2, 3, 4]\n",
+	"a: &id001 {b: 1, c: 2}\nd: {b: 1, c: 2}\ne: *id001\nf: &id002 [1, 2, 3, 4]\ng: *id002\n",
+}, {
+	// Repetitions nested in an earlier occurrence.
+	"a:\n    - [1, 2, 3, 4]\n    - [1, 2, 3, 4]\nb:\n    - [1, 2, 3, 4]\n    - [1, 2, 3, 4]\n",
+	"a: &id002\n    - &id001 [1, 2, 3, 4]\n    - *id001\nb: *id002\n",
+}}
+
+func (s *S) TestNodeDeduplicate(c *C) {
+	for i, item := range deduplicateTests {
+		c.Logf("test %d: %q", i, item.input)
+		var node yaml.Node
+		err := yaml.Unmarshal([]byte(item.input), &node)
+		c.Assert(err, IsNil)
+		data, err := yaml.Marshal(node.Deduplicate())
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, item.output)
+
+		// The original node is untouched.
+		data, err = yaml.Marshal(&node)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, item.input)
+
+		// Expanding the result gives back the same document.
+		dedup, err := node.Deduplicate().ExpandAliases()
+		c.Assert(err, IsNil)
+		expanded, err := node.ExpandAliases()
+		c.Assert(err, IsNil)
+		data, err = yaml.Marshal(dedup)
+		c.Assert(err, IsNil)
+		want, err := yaml.Marshal(expanded)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, string(want))
+	}
+}
+
+var resolveMergesTests = []struct {
+	input string
+	keys  []string
+}{{
+	"m: {a: 1, b: 2}\n",
+	[]string{"a=1", "b=2"},
+}, {
+	"base: &base {a: 1, b: 2}\nchild: {x: 0, <<: *base, b: 3}\n",
+	[]string{"x=0", "a=1", "b=3"},
+}, {
+	"a: &a {x: 1, y: 1}\nb: &b {x: 2, z: 2}\nc: {<<: [*a, *b, {w: 3}]}\n",
+	[]string{"x=1", "y=1", "z=2", "w=3"},
+}, {
+	"a: &a {x: 1}\nb: &b {<<: *a, y: 2}\nc: {<<: *b, z: 3}\n",
+	[]string{"x=1", "y=2", "z=3"},
+}}
+
+func (s *S) TestNodeResolveMerges(c *C) {
+	for i, item := range resolveMergesTests {
+		c.Logf("test %d: %q", i, item.input)
+		var node yaml.Node
+		err := yaml.Unmarshal([]byte(item.input), &node)
+		c.Assert(err, IsNil)
+		root := node.Content[0]
+		content, err := root.Content[len(root.Content)-1].ResolveMerges()
+		c.Assert(err, IsNil)
+		var keys []string
+		for j := 0; j+1 < len(content); j += 2 {
+			keys = append(keys, content[j].Value+"="+content[j+1].Value)
+		}
+		c.Assert(keys, DeepEquals, item.keys)
+	}
+
+	var node yaml.Node
+	err := yaml.Unmarshal([]byte("a: &a {<<: *a}\n"), &node)
+	c.Assert(err, IsNil)
+	_, err = node.Content[0].Content[1].ResolveMerges()
+	c.Assert(err, ErrorMatches, "yaml: anchor 'a' value contains itself")
+	_, err = node.ResolveMerges()
+	c.Assert(err, ErrorMatches, "yaml: cannot resolve merge keys of a non-mapping node")
+}
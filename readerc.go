@@ -40,13 +40,19 @@ const (
 	bom_UTF8    = "\xef\xbb\xbf"
 	bom_UTF16LE = "\xff\xfe"
 	bom_UTF16BE = "\xfe\xff"
+	bom_UTF32LE = "\xff\xfe\x00\x00"
+	bom_UTF32BE = "\x00\x00\xfe\xff"
 )
 
 // Determine the input stream encoding by checking the BOM symbol. If no BOM is
 // found, the UTF-8 encoding is assumed. Return 1 on success, 0 on failure.
+//
+// [Go] UTF-32 is recognized as well, and without a BOM the null bytes of the
+// first character, which must be ASCII, identify UTF-16 and UTF-32 as defined
+// by the YAML specification.
 func yaml_parser_determine_encoding(parser *yaml_parser_t) bool {
 	// Ensure that we had enough bytes in the raw buffer.
-	for !parser.eof && len(parser.raw_buffer)-parser.raw_buffer_pos < 3 {
+	for !parser.eof && len(parser.raw_buffer)-parser.raw_buffer_pos < 4 {
 		if !yaml_parser_update_raw_buffer(parser) {
 			return false
 		}
@@ -56,7 +62,15 @@ func yaml_parser_determine_encoding(parser *yaml_parser_t) bool {
 	buf := parser.raw_buffer
 	pos := parser.raw_buffer_pos
 	avail := len(buf) - pos
-	if avail >= 2 && buf[pos] == bom_UTF16LE[0] && buf[pos+1] == bom_UTF16LE[1] {
+	if avail >= 4 && string(buf[pos:pos+4]) == bom_UTF32LE {
+		parser.encoding = yaml_UTF32LE_ENCODING
+		parser.raw_buffer_pos += 4
+		parser.offset += 4
+	} else if avail >= 4 && string(buf[pos:pos+4]) == bom_UTF32BE {
+		parser.encoding = yaml_UTF32BE_ENCODING
+		parser.raw_buffer_pos += 4
+		parser.offset += 4
+	} else if avail >= 2 && buf[pos] == bom_UTF16LE[0] && buf[pos+1] == bom_UTF16LE[1] {
 		parser.encoding = yaml_UTF16LE_ENCODING
 		parser.raw_buffer_pos += 2
 		parser.offset += 2
@@ -68,6 +82,14 @@ func yaml_parser_determine_encoding(parser *yaml_parser_t) bool {
 		parser.encoding = yaml_UTF8_ENCODING
 		parser.raw_buffer_pos += 3
 		parser.offset += 3
+	} else if avail >= 4 && buf[pos] == 0 && buf[pos+1] == 0 && buf[pos+2] == 0 {
+		parser.encoding = yaml_UTF32BE_ENCODING
+	} else if avail >= 4 && buf[pos+1] == 0 && buf[pos+2] == 0 && buf[pos+3] == 0 {
+		parser.encoding = yaml_UTF32LE_ENCODING
+	} else if avail >= 2 && buf[pos] == 0 {
+		parser.encoding = yaml_UTF16BE_ENCODING
+	} else if avail >= 2 && buf[pos+1] == 0 {
+		parser.encoding = yaml_UTF16LE_ENCODING
 	} else {
 		parser.encoding = yaml_UTF8_ENCODING
 	}
@@ -357,6 +379,35 @@ func yaml_parser_update_buffer(parser *yaml_parser_t, length int) bool {
 					width = 2
 				}
 
+			case yaml_UTF32LE_ENCODING, yaml_UTF32BE_ENCODING:
+				// [Go] Each character is a single 32-bit value.
+
+				// Check for incomplete UTF-32 character.
+				if raw_unread < 4 {
+					if parser.eof {
+						return yaml_parser_set_reader_error(parser,
+							"incomplete UTF-32 character",
+							parser.offset, -1)
+					}
+					break inner
+				}
+
+				// Get the character.
+				b := parser.raw_buffer[parser.raw_buffer_pos : parser.raw_buffer_pos+4]
+				if parser.encoding == yaml_UTF32LE_ENCODING {
+					value = rune(b[0]) + rune(b[1])<<8 + rune(b[2])<<16 + rune(b[3])<<24
+				} else {
+					value = rune(b[3]) + rune(b[2])<<8 + rune(b[1])<<16 + rune(b[0])<<24
+				}
+				width = 4
+
+				// Check the range of the value.
+				if value >= 0xD800 && value <= 0xDFFF || value > 0x10FFFF || value < 0 {
+					return yaml_parser_set_reader_error(parser,
+						"invalid Unicode character",
+						parser.offset, int(value))
+				}
+
 			default:
 				panic("impossible")
 			}
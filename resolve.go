@@ -78,13 +78,14 @@ const (
 	mapTag       = "!!map"
 	binaryTag    = "!!binary"
 	mergeTag     = "!!merge"
+	setTag       = "!!set"
 )
 
 var longTags = make(map[string]string)
 var shortTags = make(map[string]string)
 
 func init() {
-	for _, stag := range []string{nullTag, boolTag, strTag, intTag, floatTag, timestampTag, seqTag, mapTag, binaryTag, mergeTag} {
+	for _, stag := range []string{nullTag, boolTag, strTag, intTag, floatTag, timestampTag, seqTag, mapTag, binaryTag, mergeTag, setTag} {
 		ltag := longTag(stag)
 		longTags[stag] = ltag
 		shortTags[ltag] = stag
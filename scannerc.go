@@ -520,7 +520,23 @@ func skip(parser *yaml_parser_t) {
 	parser.buffer_pos += width(parser.buffer[parser.buffer_pos])
 }
 
+// [Go] Record the style of the first line break found in the input.
+func detect_break(parser *yaml_parser_t) {
+	if parser.line_break != yaml_ANY_BREAK {
+		return
+	}
+	switch {
+	case is_crlf(parser.buffer, parser.buffer_pos):
+		parser.line_break = yaml_CRLN_BREAK
+	case parser.buffer[parser.buffer_pos] == '\r':
+		parser.line_break = yaml_CR_BREAK
+	case parser.buffer[parser.buffer_pos] == '\n':
+		parser.line_break = yaml_LN_BREAK
+	}
+}
+
 func skip_line(parser *yaml_parser_t) {
+	detect_break(parser)
 	if is_crlf(parser.buffer, parser.buffer_pos) {
 		parser.mark.index += 2
 		parser.mark.column = 0
@@ -566,6 +582,7 @@ func read(parser *yaml_parser_t, s []byte) []byte {
 
 // Copy a line break character to a string buffer and advance pointers.
 func read_line(parser *yaml_parser_t, s []byte) []byte {
+	detect_break(parser)
 	buf := parser.buffer
 	pos := parser.buffer_pos
 	switch {
@@ -16,27 +16,173 @@
 package yaml
 
 import (
+	"encoding"
+	"fmt"
 	"reflect"
+	"sort"
 	"unicode"
 )
 
-type keyList []reflect.Value
+// NaturalKeyOrder reports whether the map key a sorts before the map key b
+// in the order used by default when encoding maps: numbers and booleans
+// come first in numeric order, followed by strings sorted so that embedded
+// digits compare numerically ("a2" sorts before "a10"). Keys implementing
+// encoding.TextMarshaler are compared as the strings they encode to.
+func NaturalKeyOrder(a, b reflect.Value) bool {
+	a, _ = keyElem(a)
+	b, _ = keyElem(b)
+	return naturalLess(a, b)
+}
 
-func (l keyList) Len() int      { return len(l) }
-func (l keyList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
-func (l keyList) Less(i, j int) bool {
-	a := l[i]
-	b := l[j]
-	ak := a.Kind()
-	bk := b.Kind()
-	for (ak == reflect.Interface || ak == reflect.Ptr) && !a.IsNil() {
-		a = a.Elem()
-		ak = a.Kind()
+// LexicalKeyOrder reports whether the map key a sorts before the map key b
+// when comparing the plain text of their values byte by byte ("a10" sorts
+// before "a2"), as done by many other YAML and JSON encoders.
+func LexicalKeyOrder(a, b reflect.Value) bool {
+	a, _ = keyElem(a)
+	b, _ = keyElem(b)
+	at, bt := elemText(a), elemText(b)
+	if at != bt {
+		return at < bt
+	}
+	return a.Kind() < b.Kind()
+}
+
+// keyElem returns the value held by v after dereferencing any
+// interfaces and pointers. Values implementing encoding.TextMarshaler
+// are replaced by their text, so they sort as they are encoded. If
+// marshalling the text fails, the error is returned with the value.
+func keyElem(v reflect.Value) (reflect.Value, error) {
+	for (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && !v.IsNil() {
+		v = v.Elem()
 	}
-	for (bk == reflect.Interface || bk == reflect.Ptr) && !b.IsNil() {
-		b = b.Elem()
-		bk = b.Kind()
+	if v.Kind() != reflect.Interface && v.Kind() != reflect.Ptr {
+		if m, ok := keyTextMarshaler(v); ok {
+			text, err := m.MarshalText()
+			if err != nil {
+				return v, err
+			}
+			return reflect.ValueOf(string(text)), nil
+		}
+	}
+	return v, nil
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// keyTextMarshaler returns the encoding.TextMarshaler implemented by the
+// map key v, or by a pointer to a copy of it since keys aren't addressable.
+func keyTextMarshaler(v reflect.Value) (m encoding.TextMarshaler, ok bool) {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
 	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		return m, true
+	}
+	if v.Kind() != reflect.Ptr && reflect.PtrTo(v.Type()).Implements(textMarshalerType) {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface().(encoding.TextMarshaler), true
+	}
+	return nil, false
+}
+
+// keyText returns the text of the map key v as it would appear in
+// a plain scalar.
+func keyText(v reflect.Value) string {
+	v, _ = keyElem(v)
+	return elemText(v)
+}
+
+// elemText returns the text of the map key v, as returned by keyElem,
+// as it would appear in a plain scalar.
+func elemText(v reflect.Value) string {
+	if !v.IsValid() || (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && v.IsNil() {
+		return "null"
+	}
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// sortKeys sorts the map keys per less, or in the natural order if less
+// is nil. If ref is a mapping node, keys found in it are then moved ahead
+// of all others, in the order they have in ref. It fails if the text of
+// a key can't be marshalled.
+func sortKeys(keys []reflect.Value, less func(a, b reflect.Value) bool, ref *Node) {
+	l := keyList{keys, make([]reflect.Value, len(keys))}
+	for i, k := range keys {
+		elem, err := keyElem(k)
+		if err != nil {
+			fail(err)
+		}
+		l.elems[i] = elem
+	}
+	if less == nil {
+		sort.Sort(l)
+	} else {
+		sort.Sort(funcKeyList{l, less})
+	}
+	if ref == nil || ref.Kind != MappingNode {
+		return
+	}
+	index := make(map[string]int)
+	for i := 0; i+1 < len(ref.Content); i += 2 {
+		if _, ok := index[ref.Content[i].Value]; !ok {
+			index[ref.Content[i].Value] = i
+		}
+	}
+	pos := make([]int, len(keys))
+	for i, elem := range l.elems {
+		if j, ok := index[elemText(elem)]; ok {
+			pos[i] = j
+		} else {
+			pos[i] = len(ref.Content)
+		}
+	}
+	sort.Stable(refKeyList{keys, pos})
+}
+
+// refKeyList sorts keys per their position in a reference mapping.
+type refKeyList struct {
+	keys []reflect.Value
+	pos  []int
+}
+
+func (l refKeyList) Len() int           { return len(l.keys) }
+func (l refKeyList) Less(i, j int) bool { return l.pos[i] < l.pos[j] }
+func (l refKeyList) Swap(i, j int) {
+	l.keys[i], l.keys[j] = l.keys[j], l.keys[i]
+	l.pos[i], l.pos[j] = l.pos[j], l.pos[i]
+}
+
+// keyList sorts keys in the natural order, comparing the values held
+// in elems as returned by keyElem for each of them.
+type keyList struct {
+	keys  []reflect.Value
+	elems []reflect.Value
+}
+
+func (l keyList) Len() int           { return len(l.keys) }
+func (l keyList) Less(i, j int) bool { return naturalLess(l.elems[i], l.elems[j]) }
+func (l keyList) Swap(i, j int) {
+	l.keys[i], l.keys[j] = l.keys[j], l.keys[i]
+	l.elems[i], l.elems[j] = l.elems[j], l.elems[i]
+}
+
+// funcKeyList sorts keys per the less function.
+type funcKeyList struct {
+	keyList
+	less func(a, b reflect.Value) bool
+}
+
+func (l funcKeyList) Less(i, j int) bool { return l.less(l.keys[i], l.keys[j]) }
+
+// naturalLess returns whether the map key a sorts before the map key b
+// in the natural order, given their values as returned by keyElem.
+func naturalLess(a, b reflect.Value) bool {
+	ak := a.Kind()
+	bk := b.Kind()
 	af, aok := keyFloat(a)
 	bf, bok := keyFloat(b)
 	if aok && bok {
@@ -22,6 +22,11 @@
 
 package yaml
 
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
 // Set the writer error and return false.
 func yaml_emitter_set_writer_error(emitter *yaml_emitter_t, problem string) bool {
 	emitter.error = yaml_WRITER_ERROR
@@ -40,9 +45,41 @@ func yaml_emitter_flush(emitter *yaml_emitter_t) bool {
 		return true
 	}
 
-	if err := emitter.write_handler(emitter, emitter.buffer[:emitter.buffer_pos]); err != nil {
+	// If the output encoding is UTF-8, we don't need to recode the buffer.
+	if emitter.encoding == yaml_UTF8_ENCODING {
+		if err := emitter.write_handler(emitter, emitter.buffer[:emitter.buffer_pos]); err != nil {
+			return yaml_emitter_set_writer_error(emitter, "write error: "+err.Error())
+		}
+		emitter.buffer_pos = 0
+		return true
+	}
+
+	// Recode the buffer into the raw buffer. The buffer always holds
+	// complete UTF-8 sequences, as characters are written whole.
+	// [Go] Use the standard library rather than the C recoding code.
+	raw := emitter.raw_buffer[:0]
+	for pos := 0; pos < emitter.buffer_pos; {
+		r, w := utf8.DecodeRune(emitter.buffer[pos:emitter.buffer_pos])
+		pos += w
+		units := []uint16{uint16(r)}
+		if r >= 0x10000 {
+			r1, r2 := utf16.EncodeRune(r)
+			units = []uint16{uint16(r1), uint16(r2)}
+		}
+		for _, u := range units {
+			if emitter.encoding == yaml_UTF16LE_ENCODING {
+				raw = append(raw, byte(u), byte(u>>8))
+			} else {
+				raw = append(raw, byte(u>>8), byte(u))
+			}
+		}
+	}
+	emitter.raw_buffer = raw
+
+	if err := emitter.write_handler(emitter, emitter.raw_buffer); err != nil {
 		return yaml_emitter_set_writer_error(emitter, "write error: "+err.Error())
 	}
 	emitter.buffer_pos = 0
+	emitter.raw_buffer = emitter.raw_buffer[:0]
 	return true
 }
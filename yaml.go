@@ -28,9 +28,20 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"unicode"
 	"unicode/utf8"
 )
 
+// MapSlice encodes and decodes as a YAML mapping, preserving the order
+// of its keys. When decoding, keys introduced via merges follow the
+// mapping's own keys.
+type MapSlice []MapItem
+
+// MapItem is an item in a MapSlice.
+type MapItem struct {
+	Key, Value interface{}
+}
+
 // The Unmarshaler interface may be implemented by types to customize their
 // behavior when being unmarshaled from a YAML document.
 type Unmarshaler interface {
@@ -67,11 +78,11 @@ type Marshaler interface {
 //
 // Struct fields are only unmarshalled if they are exported (have an
 // upper case first letter), and are unmarshalled using the field name
-// lowercased as the default key. Custom keys may be defined via the
-// "yaml" name in the field tag: the content preceding the first comma
-// is used as the key, and the following comma-separated options are
-// used to tweak the marshalling process (see Marshal).
-// Conflicting names result in a runtime error.
+// lowercased as the default key, or as defined by Decoder.SetNamingStrategy.
+// Custom keys may be defined via the "yaml" name in the field tag: the
+// content preceding the first comma is used as the key, and the following
+// comma-separated options are used to tweak the marshalling process (see
+// Marshal). Conflicting names result in a runtime error.
 //
 // For example:
 //
@@ -85,14 +96,35 @@ type Marshaler interface {
 // See the documentation of Marshal for the format of tags and a list of
 // supported tag options.
 //
+// A default value for a field may be provided in a "default" tag holding
+// YAML content. It is decoded into the field whenever the field's key is
+// absent from the mapping being decoded into the struct, and also when the
+// mapping for a nested struct value is absent altogether, unless the field
+// already holds a non-zero value:
+//
+//     type T struct {
+//         Port  int      `yaml:"port" default:"8080"`
+//         Hosts []string `yaml:"hosts" default:"[localhost]"`
+//     }
+//
+// Default values are decoded on their own, regardless of the options of
+// the decoder, and a default isn't applied again within its own value.
+// A default that can't be decoded into its field panics when first used.
+//
 func Unmarshal(in []byte, out interface{}) (err error) {
 	return unmarshal(in, out, false)
 }
 
 // A Decoder reads and decodes YAML values from an input stream.
 type Decoder struct {
-	parser      *parser
-	knownFields bool
+	parser          *parser
+	knownFields     bool
+	caseInsensitive bool
+	orderedMaps     bool
+	strict          bool
+	strictStrings   bool
+	allowedTags     []string
+	structOpts      structOptions
 }
 
 // NewDecoder returns a new decoder that reads from r.
@@ -111,6 +143,70 @@ func (dec *Decoder) KnownFields(enable bool) {
 	dec.knownFields = enable
 }
 
+// OrderedMaps enables decoding mappings into a MapSlice rather than into
+// a map when no other type is implied by the value being decoded into,
+// so that the order of keys is preserved.
+func (dec *Decoder) OrderedMaps(enable bool) {
+	dec.orderedMaps = enable
+}
+
+// UseJSONTags enables using the "json" tag of struct fields that have
+// no "yaml" tag, so the same types may be used with encoding/json.
+// The key and the omitempty and string options are honoured, and
+// embedded structs without a key are inlined as encoding/json does.
+func (dec *Decoder) UseJSONTags(enable bool) {
+	dec.structOpts.jsonTags = enable
+}
+
+// CaseInsensitive enables matching keys in decoded mappings to the
+// struct fields ignoring differences in case, as strings.EqualFold does.
+// A key matching a field exactly is always preferred, and keys that
+// fold into multiple fields or multiple keys that fold into the same
+// field are reported as errors.
+func (dec *Decoder) CaseInsensitive(enable bool) {
+	dec.caseInsensitive = enable
+}
+
+// Strict enables rejecting the constructs that make documents harder to
+// audit, on top of duplicated keys which are always rejected. In strict
+// mode the following are reported as errors:
+//
+//   - keys that don't match a field of the struct being decoded into,
+//     as with KnownFields;
+//   - merge keys ("<<");
+//   - aliases, which are not expanded;
+//   - mapping keys that aren't strings;
+//   - plain scalars of types other than !!str decoded into a string,
+//     as with StrictStrings;
+//   - explicit tags other than !!null, !!bool, !!int, !!float, !!str,
+//     !!seq, !!map, and those allowed with AllowTags.
+func (dec *Decoder) Strict() {
+	dec.strict = true
+	dec.knownFields = true
+	dec.strictStrings = true
+}
+
+// StrictStrings enables rejecting scalars of a type other than !!str when
+// they are decoded into a string, such as the float in "version: 1.20",
+// the bool in "name: true", or the explicitly tagged int in !!int "5",
+// which would otherwise silently keep their text. Plain values must be
+// quoted, or explicitly tagged as !!str.
+func (dec *Decoder) StrictStrings(enable bool) {
+	dec.strictStrings = enable
+}
+
+// AllowTags adds the given tags to the ones accepted in strict mode.
+// See Strict for details.
+func (dec *Decoder) AllowTags(tags ...string) {
+	dec.allowedTags = append(dec.allowedTags, tags...)
+}
+
+// SetNamingStrategy defines how the keys of struct fields that have
+// no key in their tag are derived from the field names.
+func (dec *Decoder) SetNamingStrategy(s NamingStrategy) {
+	dec.structOpts.naming = s
+}
+
 // Decode reads the next YAML-encoded value from its input
 // and stores it in the value pointed to by v.
 //
@@ -119,6 +215,20 @@ func (dec *Decoder) KnownFields(enable bool) {
 func (dec *Decoder) Decode(v interface{}) (err error) {
 	d := newDecoder()
 	d.knownFields = dec.knownFields
+	d.caseInsensitive = dec.caseInsensitive
+	d.orderedMaps = dec.orderedMaps
+	d.strict = dec.strict
+	d.strictStrings = dec.strictStrings
+	if dec.strict {
+		d.allowedTags = make(map[string]bool)
+		for _, tag := range strictTags {
+			d.allowedTags[tag] = true
+		}
+		for _, tag := range dec.allowedTags {
+			d.allowedTags[shortTag(tag)] = true
+		}
+	}
+	d.structOpts = dec.structOpts
 	defer handleErr(&err)
 	node := dec.parser.parse()
 	if node == nil {
@@ -178,9 +288,10 @@ func unmarshal(in []byte, out interface{}, strict bool) (err error) {
 //
 // Struct fields are only marshalled if they are exported (have an upper case
 // first letter), and are marshalled using the field name lowercased as the
-// default key. Custom keys may be defined via the "yaml" name in the field
-// tag: the content preceding the first comma is used as the key, and the
-// following comma-separated options are used to tweak the marshalling process.
+// default key, or as defined by Encoder.SetNamingStrategy. Custom keys may be
+// defined via the "yaml" name in the field tag: the content preceding the
+// first comma is used as the key, and the following comma-separated options
+// are used to tweak the marshalling process.
 // Conflicting names result in a runtime error.
 //
 // The field tag format accepted is:
@@ -204,6 +315,18 @@ func unmarshal(in []byte, out interface{}, strict bool) (err error) {
 //                  they were part of the outer struct. For maps, keys must
 //                  not conflict with the yaml keys of other struct fields.
 //
+//     merge        Marshal the field, which must be a struct, a map, or a
+//                  slice of them, as the value of a merge key ("<<") at the
+//                  start of the mapping, unless it is nil. When unmarshalling,
+//                  the value of the merge key is stored in the field instead
+//                  of being merged into the other fields. Combined with
+//                  Encoder.AliasSharedValues, this lets structs refer to a
+//                  shared anchored mapping.
+//
+//     required     Only meaningful when unmarshalling. If the key is absent
+//                  from a mapping decoded into the struct, an error naming
+//                  the field is reported in the resulting *yaml.TypeError.
+//
 // In addition, if the key is "-", the field is ignored.
 //
 // For example:
@@ -278,6 +401,179 @@ func (e *Encoder) SetIndent(spaces int) {
 	e.encoder.indent = spaces
 }
 
+// CompactSequences changes whether block sequences that are mapping values
+// are indented relative to their key, as they are by default, or are
+// written in the compact form where the "- " indicators are aligned with
+// the key, as usual in Kubernetes and Ansible files:
+//
+//     key:
+//     - item
+//
+func (e *Encoder) CompactSequences(enable bool) {
+	yaml_emitter_set_indentless_sequences(&e.encoder.emitter, enable)
+}
+
+// ExplicitDocumentStart changes whether every document is preceded by
+// the "---" marker, rather than only those after the first one.
+func (e *Encoder) ExplicitDocumentStart(enable bool) {
+	e.encoder.explicitStart = enable
+}
+
+// ExplicitDocumentEnd changes whether every document is terminated by
+// the "..." marker.
+func (e *Encoder) ExplicitDocumentEnd(enable bool) {
+	e.encoder.explicitEnd = enable
+}
+
+// SetVersionDirective changes the version announced by a %YAML directive
+// at the start of every document, which may be either "1.1" or "1.2".
+// The empty string, the default, omits the directive.
+func (e *Encoder) SetVersionDirective(version string) {
+	if version == "" {
+		e.encoder.version = nil
+		return
+	}
+	v := parseVersion(version)
+	if v == nil {
+		panic(fmt.Sprintf("yaml: unsupported YAML version %q", version))
+	}
+	e.encoder.version = v
+}
+
+// AddTagDirective adds a %TAG directive at the start of every document,
+// associating the tag handle, such as "!e!", with the given prefix.
+// Tags starting with the prefix are written in the shorthand form using
+// the handle.
+func (e *Encoder) AddTagDirective(handle, prefix string) {
+	e.encoder.tagDirectives = append(e.encoder.tagDirectives, yaml_tag_directive_t{
+		handle: []byte(handle),
+		prefix: []byte(prefix),
+	})
+}
+
+// SetCanonical changes whether the output is in the canonical form
+// defined by the YAML specification, where every node has an explicit
+// tag, collections use the flow style, and scalars are double-quoted.
+func (e *Encoder) SetCanonical(enable bool) {
+	yaml_emitter_set_canonical(&e.encoder.emitter, enable)
+}
+
+// SetLineBreak changes the style of the line breaks in the output,
+// which are line feeds by default.
+func (e *Encoder) SetLineBreak(b LineBreak) {
+	if b != LFBreak && b != CRLFBreak && b != CRBreak {
+		panic("yaml: unknown line break style")
+	}
+	e.encoder.lineBreak = b
+	yaml_emitter_set_break(&e.encoder.emitter, b.yamlBreak())
+}
+
+// PreserveLineBreak changes whether documents encoded from a document
+// Node decoded from YAML text with carriage returns keep the line break
+// style of that text, rather than using the style defined via SetLineBreak.
+func (e *Encoder) PreserveLineBreak(enable bool) {
+	e.encoder.preserveBreak = enable
+}
+
+// SetEncoding changes the character encoding of the output, which is
+// UTF-8 by default. UTF-16 output always begins with a byte order mark.
+// It must be called before the first call to Encode.
+func (e *Encoder) SetEncoding(enc Encoding) {
+	if enc != UTF8Encoding && enc != UTF16LEEncoding && enc != UTF16BEEncoding {
+		panic("yaml: unknown encoding")
+	}
+	e.encoder.encoding = enc
+}
+
+// WriteBOM changes whether UTF-8 output begins with a byte order mark.
+// It must be called before the first call to Encode.
+func (e *Encoder) WriteBOM(enable bool) {
+	yaml_emitter_set_utf8_bom(&e.encoder.emitter, enable)
+}
+
+// ASCIIOnly changes whether the output is restricted to ASCII characters.
+// When enabled, scalars with other characters are double-quoted with those
+// characters escaped as \uXXXX or \UXXXXXXXX, and comments have them
+// written as the same escape sequences, though these are not interpreted
+// when decoding.
+func (e *Encoder) ASCIIOnly(enable bool) {
+	yaml_emitter_set_unicode(&e.encoder.emitter, !enable)
+}
+
+// AliasSharedValues changes whether pointers, maps, and slices referenced
+// from several places in a value are encoded once with an anchor, and then
+// as aliases to it, which also allows encoding cyclic values. Otherwise,
+// shared values are encoded in full every time, and cycles are an error.
+func (e *Encoder) AliasSharedValues(enable bool) {
+	e.encoder.aliases = enable
+}
+
+// EncodeSets changes whether maps with empty struct values, such as
+// map[string]struct{}, are encoded as a !!set, writing each key on its
+// own with an explicit "?" indicator and no value. Otherwise they are
+// encoded as mappings of keys to empty mappings.
+func (e *Encoder) EncodeSets(enable bool) {
+	e.encoder.sets = enable
+}
+
+// SetQuoteStyle changes the style used for strings that must be quoted,
+// such as those that would otherwise be decoded as a different type,
+// which may be either DoubleQuotedStyle, the default, or SingleQuotedStyle.
+// Strings that cannot be represented within single quotes, such as those
+// holding non-printable characters, are still double-quoted.
+func (e *Encoder) SetQuoteStyle(style Style) {
+	if style != DoubleQuotedStyle && style != SingleQuotedStyle {
+		panic("yaml: quote style must be DoubleQuotedStyle or SingleQuotedStyle")
+	}
+	e.encoder.quoteStyle = style
+}
+
+// QuoteStrings changes whether all strings are quoted, using the style
+// defined via SetQuoteStyle, rather than only those that must be. This
+// includes string mapping keys.
+func (e *Encoder) QuoteStrings(enable bool) {
+	e.encoder.quoteAll = enable
+}
+
+// SetWidth changes the preferred width of the output lines, beyond which
+// long plain, quoted, and folded scalars are broken into multiple lines.
+// Lines are never broken when width is negative, which is the default.
+// Widths up to twice the indentation are too narrow to be respected, and
+// are replaced by a width of 80.
+func (e *Encoder) SetWidth(width int) {
+	yaml_emitter_set_width(&e.encoder.emitter, width)
+}
+
+// SetKeyOrder defines the order in which the keys of Go maps are encoded,
+// with less reporting whether key a sorts before key b. NaturalKeyOrder is
+// used by default, and LexicalKeyOrder offers a plain sorting alternative.
+// Nodes and MapSlice values always preserve the order of their keys.
+func (e *Encoder) SetKeyOrder(less func(a, b reflect.Value) bool) {
+	e.encoder.keyLess = less
+}
+
+// PreserveKeyOrder makes the keys of Go maps follow the order of the
+// equivalent keys in node, which is usually the document the values were
+// decoded from. Keys not found in node are encoded after all others, in
+// the order defined via SetKeyOrder. A nil node disables the feature.
+func (e *Encoder) PreserveKeyOrder(node *Node) {
+	e.encoder.keyOrder = node
+}
+
+// UseJSONTags enables using the "json" tag of struct fields that have
+// no "yaml" tag, so the same types may be used with encoding/json.
+// The key and the omitempty and string options are honoured, and
+// embedded structs without a key are inlined as encoding/json does.
+func (e *Encoder) UseJSONTags(enable bool) {
+	e.encoder.structOpts.jsonTags = enable
+}
+
+// SetNamingStrategy defines how the keys of struct fields that have
+// no key in their tag are derived from the field names.
+func (e *Encoder) SetNamingStrategy(s NamingStrategy) {
+	e.encoder.structOpts.naming = s
+}
+
 // Close closes the encoder by writing any remaining data.
 // It does not write a stream terminating string "...".
 func (e *Encoder) Close() (err error) {
@@ -286,6 +582,34 @@ func (e *Encoder) Close() (err error) {
 	return nil
 }
 
+// Canonicalize returns the canonical form of all documents in the YAML
+// content in, which is the same for documents with equal content.
+// Aliases and merge keys are expanded as by Node.ExpandAliases, comments,
+// directives, and presentation details are dropped, null, boolean,
+// integer and float values are normalized, mapping keys are sorted, and
+// the result is written in the canonical output style (see SetCanonical).
+func Canonicalize(in []byte) (out []byte, err error) {
+	defer handleErr(&err)
+	p := newParser(in)
+	defer p.destroy()
+	e := newEncoder()
+	defer e.destroy()
+	yaml_emitter_set_canonical(&e.emitter, true)
+	e.init()
+	for {
+		node := p.parse()
+		if node == nil {
+			break
+		}
+		x := &expander{aliases: make(map[*Node]bool)}
+		node = x.expand(node)
+		canonicalNode(node)
+		e.marshalDoc("", reflect.ValueOf(node))
+	}
+	e.finish()
+	return e.out, nil
+}
+
 func handleErr(err *error) {
 	if v := recover(); v != nil {
 		if e, ok := v.(yamlError); ok {
@@ -320,6 +644,20 @@ func (e *TypeError) Error() string {
 	return fmt.Sprintf("yaml: unmarshal errors:\n  %s", strings.Join(e.Errors, "\n  "))
 }
 
+// An UnsupportedValueError is returned by Marshal when attempting
+// to encode an unsupported value, such as a cyclic one.
+type UnsupportedValueError struct {
+	Value reflect.Value
+	// Path holds the path from the value back to itself in a cycle,
+	// made of mapping keys and sequence indexes such as "a.b[0]".
+	Path string
+	Str  string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "yaml: " + e.Str
+}
+
 type Kind uint32
 
 const (
@@ -409,16 +747,95 @@ type Node struct {
 	// FootComment holds any comments following the node and before empty lines.
 	FootComment string
 
+	// Document holds the properties of the document beyond its content,
+	// or nil if it has none. Only valid when Kind is DocumentNode.
+	Document *DocumentInfo
+
 	// Line and Column hold the node position in the decoded YAML text.
 	// These fields are not respected when encoding the node.
 	Line   int
 	Column int
 }
 
+// DocumentInfo holds the properties of a document node that aren't
+// shared by other kinds of nodes.
+type DocumentInfo struct {
+	// Version and TagDirectives hold the %YAML and %TAG directives
+	// preceding the document.
+	Version       string
+	TagDirectives []TagDirective
+
+	// LineBreak holds the line break style of the decoded YAML text
+	// when it isn't made of line feeds. See Encoder.PreserveLineBreak.
+	LineBreak LineBreak
+}
+
+// LineBreak defines the style of line breaks in YAML content.
+type LineBreak uint32
+
+const (
+	LFBreak   LineBreak = 1 + iota // Line feed, as on Unix systems.
+	CRLFBreak                      // Carriage return and line feed, as on Windows.
+	CRBreak                        // Carriage return, as on classic Mac OS.
+)
+
+// yamlBreak returns the emitter setting for the line break style,
+// with line feeds being the default.
+func (b LineBreak) yamlBreak() yaml_break_t {
+	switch b {
+	case CRLFBreak:
+		return yaml_CRLN_BREAK
+	case CRBreak:
+		return yaml_CR_BREAK
+	}
+	return yaml_LN_BREAK
+}
+
+// Encoding defines the character encoding of YAML output.
+type Encoding uint32
+
+const (
+	UTF8Encoding    Encoding = 1 + iota // UTF-8, the default.
+	UTF16LEEncoding                     // UTF-16 little-endian, with a BOM.
+	UTF16BEEncoding                     // UTF-16 big-endian, with a BOM.
+)
+
+// yamlEncoding returns the emitter setting for the encoding,
+// with UTF-8 being the default.
+func (enc Encoding) yamlEncoding() yaml_encoding_t {
+	switch enc {
+	case UTF16LEEncoding:
+		return yaml_UTF16LE_ENCODING
+	case UTF16BEEncoding:
+		return yaml_UTF16BE_ENCODING
+	}
+	return yaml_UTF8_ENCODING
+}
+
+// lineBreakOf returns the line break style of the parser setting b,
+// or zero for line feeds and when no line break was found.
+func lineBreakOf(b yaml_break_t) LineBreak {
+	switch b {
+	case yaml_CRLN_BREAK:
+		return CRLFBreak
+	case yaml_CR_BREAK:
+		return CRBreak
+	}
+	return 0
+}
+
+// TagDirective holds a %TAG directive, which associates a tag handle such
+// as "!e!" with the prefix of the tags written in shorthand form using it.
+type TagDirective struct {
+	Handle string
+	Prefix string
+}
+
 // IsZero returns whether the node has all of its fields unset.
 func (n *Node) IsZero() bool {
 	return n.Kind == 0 && n.Style == 0 && n.Tag == "" && n.Value == "" && n.Anchor == "" && n.Alias == nil && n.Content == nil &&
-		n.HeadComment == "" && n.LineComment == "" && n.FootComment == "" && n.Line == 0 && n.Column == 0
+		n.HeadComment == "" && n.LineComment == "" && n.FootComment == "" && n.Document == nil &&
+		n.Line == 0 && n.Column == 0
 }
 
 
@@ -482,6 +899,304 @@ func (n *Node) SetString(s string) {
 	}
 }
 
+// ExpandAliases returns a deep copy of the node in which every alias is
+// replaced by a copy of the node it refers to, and every merge key ("<<")
+// by the mapping entries it introduces. Anchors are dropped from the copy
+// since nothing refers to them anymore. As when decoding into Go values,
+// a document with excessive aliasing is rejected.
+func (n *Node) ExpandAliases() (expanded *Node, err error) {
+	defer handleErr(&err)
+	x := &expander{aliases: make(map[*Node]bool)}
+	return x.expand(n), nil
+}
+
+// expander copies a node tree while resolving its aliases and merge keys,
+// accounting for alias expansion the same way the decoder does.
+type expander struct {
+	count      int
+	aliasCount int
+	aliasDepth int
+	aliases    map[*Node]bool
+}
+
+func (x *expander) expand(n *Node) *Node {
+	x.count++
+	if x.aliasDepth > 0 {
+		x.aliasCount++
+	}
+	if x.aliasCount > 100 && x.count > 1000 && float64(x.aliasCount)/float64(x.count) > allowedAliasRatio(x.count) {
+		failf("document contains excessive aliasing")
+	}
+	if n.Kind == AliasNode {
+		if n.Alias == nil {
+			failf("unknown anchor '%s' referenced", n.Value)
+		}
+		if x.aliases[n] {
+			failf("anchor '%s' value contains itself", n.Value)
+		}
+		x.aliases[n] = true
+		x.aliasDepth++
+		c := x.expand(n.Alias)
+		x.aliasDepth--
+		delete(x.aliases, n)
+		// The copy takes the place of the alias in the document.
+		c.HeadComment = n.HeadComment
+		c.LineComment = n.LineComment
+		c.FootComment = n.FootComment
+		c.Line = n.Line
+		c.Column = n.Column
+		return c
+	}
+	c := *n
+	c.Anchor = ""
+	c.Content = nil
+	if n.Kind == MappingNode {
+		c.Content = x.mapping(n)
+	} else if len(n.Content) > 0 {
+		c.Content = make([]*Node, len(n.Content))
+		for i, ni := range n.Content {
+			c.Content[i] = x.expand(ni)
+		}
+	}
+	return &c
+}
+
+// mapping returns the expanded entries of the mapping node n. Entries
+// introduced by merge keys are accounted for as alias expansions.
+func (x *expander) mapping(n *Node) []*Node {
+	pairs := mergedPairs(n, x.aliases)
+	content := make([]*Node, 0, 2*len(pairs))
+	for _, p := range pairs {
+		if p.merged {
+			x.aliasDepth++
+		}
+		content = append(content, x.expand(p.key), x.expand(p.value))
+		if p.merged {
+			x.aliasDepth--
+		}
+	}
+	return content
+}
+
+// ResolveMerges returns the keys and values of the mapping node n, laid
+// out as in its Content, with each merge key ("<<") replaced by the
+// entries of the mappings it refers to. Keys defined by n itself take
+// precedence over merged ones, and earlier merged mappings over later
+// ones. Merged entries take the place of the merge key that introduced
+// them. The returned nodes are not copied.
+func (n *Node) ResolveMerges() (content []*Node, err error) {
+	defer handleErr(&err)
+	if n.Kind != MappingNode {
+		failf("cannot resolve merge keys of a non-mapping node")
+	}
+	pairs := mergedPairs(n, make(map[*Node]bool))
+	content = make([]*Node, 0, 2*len(pairs))
+	for _, p := range pairs {
+		content = append(content, p.key, p.value)
+	}
+	return content, nil
+}
+
+// mergedPair is an entry of a mapping with its merge keys resolved.
+type mergedPair struct {
+	key, value *Node
+	// merged is set when the entry was introduced by a merge key.
+	merged bool
+}
+
+// mergedPairs returns the entries of the mapping node n with its merge
+// keys resolved. The aliases being resolved are tracked in aliases, so
+// mappings that merge themselves are reported.
+func mergedPairs(n *Node, aliases map[*Node]bool) []mergedPair {
+	defined := make(map[mergeKey]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if k := n.Content[i]; !isMerge(k) {
+			if mk, ok := mergeKeyOf(k); ok {
+				defined[mk] = true
+			}
+		}
+	}
+	pairs := make([]mergedPair, 0, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if !isMerge(k) {
+			pairs = append(pairs, mergedPair{key: k, value: v})
+			continue
+		}
+		var sources []*Node
+		switch v.Kind {
+		case MappingNode, AliasNode:
+			sources = []*Node{v}
+		case SequenceNode:
+			sources = v.Content
+		default:
+			failWantMap()
+		}
+		for _, src := range sources {
+			m := src
+			if m.Kind == AliasNode {
+				if m.Alias == nil {
+					failf("unknown anchor '%s' referenced", m.Value)
+				}
+				if aliases[src] {
+					failf("anchor '%s' value contains itself", src.Value)
+				}
+				m = m.Alias
+			}
+			if m.Kind != MappingNode {
+				failWantMap()
+			}
+			aliases[src] = true
+			for _, p := range mergedPairs(m, aliases) {
+				if mk, ok := mergeKeyOf(p.key); ok {
+					if defined[mk] {
+						continue
+					}
+					defined[mk] = true
+				}
+				p.merged = true
+				pairs = append(pairs, p)
+			}
+			delete(aliases, src)
+		}
+	}
+	return pairs
+}
+
+// mergeKey identifies a scalar mapping key when resolving merges.
+type mergeKey struct {
+	tag, value string
+}
+
+func mergeKeyOf(n *Node) (key mergeKey, ok bool) {
+	if n.Kind == AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	if n.Kind != ScalarNode {
+		return key, false
+	}
+	return mergeKey{n.ShortTag(), n.Value}, true
+}
+
+// dedupMinSize is the number of nodes a mapping or sequence must hold,
+// itself included, for Deduplicate to replace its repetitions by aliases.
+// A mapping with two scalar entries is just large enough.
+const dedupMinSize = 5
+
+// Deduplicate returns a deep copy of the node in which repeated mappings
+// and sequences are replaced by aliases to their first occurrence, which
+// is given an anchor when it has none. It is the inverse of ExpandAliases.
+// Subtrees that are too small, or that define anchors of their own, are
+// left untouched.
+func (n *Node) Deduplicate() *Node {
+	c := copyNode(n, make(map[*Node]*Node))
+	d := &deduplicator{
+		ids:      make(map[string]int),
+		subtrees: make(map[*Node]subtree),
+		first:    make(map[int]*Node),
+		anchors:  make(map[string]bool),
+	}
+	d.identify(c)
+	d.replace(c)
+	return c
+}
+
+// copyNode returns a deep copy of n, with aliases pointing into the copy.
+func copyNode(n *Node, copies map[*Node]*Node) *Node {
+	c := *n
+	copies[n] = &c
+	if a, ok := copies[n.Alias]; ok {
+		c.Alias = a
+	}
+	if n.Content != nil {
+		c.Content = make([]*Node, len(n.Content))
+		for i, ni := range n.Content {
+			c.Content[i] = copyNode(ni, copies)
+		}
+	}
+	return &c
+}
+
+// subtree describes the tree rooted at a node. Identical trees share the
+// same id.
+type subtree struct {
+	id       int
+	size     int
+	anchored bool
+}
+
+type deduplicator struct {
+	ids      map[string]int
+	subtrees map[*Node]subtree
+	first    map[int]*Node
+	anchors  map[string]bool
+	last     int
+}
+
+func (d *deduplicator) identify(n *Node) subtree {
+	if n.Anchor != "" {
+		d.anchors[n.Anchor] = true
+	}
+	t := subtree{size: 1, anchored: n.Anchor != ""}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %d %q %q %p", n.Kind, n.Style, n.Tag, n.Value, n.Alias)
+	for _, ni := range n.Content {
+		nt := d.identify(ni)
+		t.size += nt.size
+		t.anchored = t.anchored || nt.anchored
+		fmt.Fprintf(&b, " %d %q %q %q", nt.id, ni.HeadComment, ni.LineComment, ni.FootComment)
+	}
+	id, ok := d.ids[b.String()]
+	if !ok {
+		id = len(d.ids)
+		d.ids[b.String()] = id
+	}
+	t.id = id
+	d.subtrees[n] = t
+	return t
+}
+
+func (d *deduplicator) replace(n *Node) {
+	for i, ni := range n.Content {
+		t := d.subtrees[ni]
+		if t.anchored || t.size < dedupMinSize || ni.Kind != MappingNode && ni.Kind != SequenceNode {
+			d.replace(ni)
+			continue
+		}
+		first, ok := d.first[t.id]
+		if !ok {
+			d.first[t.id] = ni
+			d.replace(ni)
+			continue
+		}
+		if first.Anchor == "" {
+			first.Anchor = d.anchor()
+		}
+		n.Content[i] = &Node{
+			Kind:        AliasNode,
+			Value:       first.Anchor,
+			Alias:       first,
+			HeadComment: ni.HeadComment,
+			LineComment: ni.LineComment,
+			FootComment: ni.FootComment,
+			Line:        ni.Line,
+			Column:      ni.Column,
+		}
+	}
+}
+
+// anchor returns a new anchor name not yet in use in the document.
+func (d *deduplicator) anchor() string {
+	for {
+		d.last++
+		name := fmt.Sprintf("id%03d", d.last)
+		if !d.anchors[name] {
+			d.anchors[name] = true
+			return name
+		}
+	}
+}
+
 // --------------------------------------------------------------------------
 // Maintain a mapping of keys to structure field indexes
 
@@ -497,9 +1212,25 @@ type structInfo struct {
 	// contains an ,inline map, or -1 if there's none.
 	InlineMap int
 
+	// MergeField is the number of the field in the struct that is
+	// flagged as ,merge, or -1 if there's none. It holds the value
+	// of the merge key ("<<") of the mapping.
+	MergeField int
+
 	// InlineUnmarshalers holds indexes to inlined fields that
 	// contain unmarshaler values.
 	InlineUnmarshalers [][]int
+
+	// Required holds the ids of the fields flagged as ,required.
+	Required []int
+
+	// FoldedKeys maps the case folded form of keys to the ids
+	// of the fields holding them.
+	FoldedKeys map[string][]int
+
+	// Defaults holds the ids of the fields that have a default value,
+	// or that are structs holding fields with default values.
+	Defaults []int
 }
 
 type fieldInfo struct {
@@ -507,6 +1238,14 @@ type fieldInfo struct {
 	Num       int
 	OmitEmpty bool
 	Flow      bool
+	Required  bool
+	// String is set for numeric and boolean fields that are
+	// encoded as strings, per the json ,string option.
+	String bool
+	// Default holds the value provided via the "default" tag, if any,
+	// and DefaultText the text of the tag.
+	Default     *Node
+	DefaultText string
 	// Id holds the unique field identifier, so we can cheaply
 	// check for field duplicates without maintaining an extra map.
 	Id int
@@ -515,7 +1254,132 @@ type fieldInfo struct {
 	Inline []int
 }
 
-var structMap = make(map[reflect.Type]*structInfo)
+// index returns the field index of info in its struct, as accepted
+// by reflect.Type.FieldByIndex.
+func (info *fieldInfo) index() []int {
+	if info.Inline == nil {
+		return []int{info.Num}
+	}
+	return info.Inline
+}
+
+// A NamingStrategy defines how the keys of struct fields are derived
+// from the field names, for fields that have no key in their tag.
+type NamingStrategy int
+
+const (
+	// LowerCaseNaming lowercases the field name: MaxRetries becomes maxretries.
+	// This is the default strategy.
+	LowerCaseNaming NamingStrategy = iota
+
+	// SnakeCaseNaming splits the field name into lowercased words joined
+	// by underscores: MaxRetries becomes max_retries.
+	SnakeCaseNaming
+
+	// KebabCaseNaming splits the field name into lowercased words joined
+	// by dashes: MaxRetries becomes max-retries.
+	KebabCaseNaming
+
+	// CamelCaseNaming lowercases the leading word of the field name:
+	// MaxRetries becomes maxRetries, and HTTPServer becomes httpServer.
+	CamelCaseNaming
+)
+
+// key returns the key for a struct field with the given name.
+func (s NamingStrategy) key(name string) string {
+	switch s {
+	case SnakeCaseNaming:
+		return strings.ToLower(strings.Join(splitWords(name), "_"))
+	case KebabCaseNaming:
+		return strings.ToLower(strings.Join(splitWords(name), "-"))
+	case CamelCaseNaming:
+		words := splitWords(name)
+		words[0] = strings.ToLower(words[0])
+		return strings.Join(words, "")
+	}
+	return strings.ToLower(name)
+}
+
+// splitWords splits a Go identifier into its words, keeping acronyms
+// and trailing digits together: HTTPServer2Addr is split into HTTP,
+// Server2, and Addr.
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		switch {
+		case cur == '_':
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		case i == start:
+			continue
+		case unicode.IsUpper(cur) && !unicode.IsUpper(prev):
+		case unicode.IsUpper(cur) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+		default:
+			continue
+		}
+		words = append(words, string(runes[start:i]))
+		start = i
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	if words == nil {
+		words = []string{name}
+	}
+	return words
+}
+
+// isStringifiable returns whether values of type t may be encoded
+// within a string, as the json ,string option does.
+func isStringifiable(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+// foldKey returns a canonical form of s under Unicode case folding, so
+// that foldKey(a) == foldKey(b) whenever strings.EqualFold(a, b) holds.
+func foldKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, s)
+}
+
+// structOptions holds the settings that affect how the fields of a
+// struct are mapped into keys.
+type structOptions struct {
+	naming NamingStrategy
+
+	// jsonTags enables using the "json" tag of fields that
+	// have no "yaml" tag.
+	jsonTags bool
+}
+
+type structKey struct {
+	typ  reflect.Type
+	opts structOptions
+}
+
+var structMap = make(map[structKey]*structInfo)
 var fieldMapMutex sync.RWMutex
 var unmarshalerType reflect.Type
 
@@ -524,9 +1388,9 @@ func init() {
 	unmarshalerType = reflect.ValueOf(&v).Elem().Type()
 }
 
-func getStructInfo(st reflect.Type) (*structInfo, error) {
+func getStructInfo(st reflect.Type, opts structOptions) (*structInfo, error) {
 	fieldMapMutex.RLock()
-	sinfo, found := structMap[st]
+	sinfo, found := structMap[structKey{st, opts}]
 	fieldMapMutex.RUnlock()
 	if found {
 		return sinfo, nil
@@ -536,6 +1400,7 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 	fieldsMap := make(map[string]fieldInfo)
 	fieldsList := make([]fieldInfo, 0, n)
 	inlineMap := -1
+	mergeField := -1
 	inlineUnmarshalers := [][]int(nil)
 	for i := 0; i != n; i++ {
 		field := st.Field(i)
@@ -549,13 +1414,40 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 		if tag == "" && strings.Index(string(field.Tag), ":") < 0 {
 			tag = string(field.Tag)
 		}
+		jsonTag := tag == "" && opts.jsonTags
+		if jsonTag {
+			tag = field.Tag.Get("json")
+		}
 		if tag == "-" {
 			continue
 		}
 
 		inline := false
+		merge := false
 		fields := strings.Split(tag, ",")
-		if len(fields) > 1 {
+		if jsonTag {
+			// Follow encoding/json: embedded structs without a name
+			// are inlined, and unknown options are ignored.
+			ftype := field.Type
+			if ftype.Kind() == reflect.Ptr {
+				ftype = ftype.Elem()
+			}
+			inline = field.Anonymous && fields[0] == "" && ftype.Kind() == reflect.Struct
+			for _, flag := range fields[1:] {
+				switch flag {
+				case "omitempty":
+					info.OmitEmpty = true
+				case "inline":
+					inline = true
+				case "string":
+					info.String = isStringifiable(field.Type)
+				}
+			}
+			if field.PkgPath != "" && !inline {
+				continue // Private embedded field
+			}
+			tag = fields[0]
+		} else if len(fields) > 1 {
 			for _, flag := range fields[1:] {
 				switch flag {
 				case "omitempty":
@@ -564,6 +1456,10 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 					info.Flow = true
 				case "inline":
 					inline = true
+				case "merge":
+					merge = true
+				case "required":
+					info.Required = true
 				default:
 					return nil, errors.New(fmt.Sprintf("unsupported flag %q in tag %q of type %s", flag, tag, st))
 				}
@@ -571,6 +1467,23 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 			tag = fields[0]
 		}
 
+		if merge {
+			if inline {
+				return nil, errors.New("options ,inline and ,merge are incompatible in struct " + st.String())
+			}
+			if mergeField >= 0 {
+				return nil, errors.New("multiple ,merge fields in struct " + st.String())
+			}
+			if field.PkgPath != "" {
+				return nil, errors.New("option ,merge needs an exported field in struct " + st.String())
+			}
+			if !isMergeable(field.Type) {
+				return nil, errors.New("option ,merge needs a struct, a map, or a slice of them in struct " + st.String())
+			}
+			mergeField = info.Num
+			continue
+		}
+
 		if inline {
 			switch field.Type.Kind() {
 			case reflect.Map:
@@ -592,7 +1505,7 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 				if reflect.PtrTo(ftype).Implements(unmarshalerType) {
 					inlineUnmarshalers = append(inlineUnmarshalers, []int{i})
 				} else {
-					sinfo, err := getStructInfo(ftype)
+					sinfo, err := getStructInfo(ftype, opts)
 					if err != nil {
 						return nil, err
 					}
@@ -623,7 +1536,18 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 		if tag != "" {
 			info.Key = tag
 		} else {
-			info.Key = strings.ToLower(field.Name)
+			info.Key = opts.naming.key(field.Name)
+		}
+
+		if value, ok := field.Tag.Lookup("default"); ok {
+			// The value is only checked against the field type when it
+			// is first used, as the type may lead back to this struct.
+			node, err := parseDefault(value)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid default value %q for field %s of type %s: %v", value, field.Name, st, err))
+			}
+			info.Default = node
+			info.DefaultText = value
 		}
 
 		if _, found = fieldsMap[info.Key]; found {
@@ -636,19 +1560,65 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 		fieldsMap[info.Key] = info
 	}
 
+	var required, defaults []int
+	foldedKeys := make(map[string][]int)
+	for _, finfo := range fieldsList {
+		fkey := foldKey(finfo.Key)
+		foldedKeys[fkey] = append(foldedKeys[fkey], finfo.Id)
+		if finfo.Required {
+			required = append(required, finfo.Id)
+		}
+		if finfo.Default != nil || hasDefaults(st.FieldByIndex(finfo.index()).Type, opts) {
+			defaults = append(defaults, finfo.Id)
+		}
+	}
+
 	sinfo = &structInfo{
 		FieldsMap:          fieldsMap,
 		FieldsList:         fieldsList,
 		InlineMap:          inlineMap,
+		MergeField:         mergeField,
 		InlineUnmarshalers: inlineUnmarshalers,
+		FoldedKeys:         foldedKeys,
+		Required:           required,
+		Defaults:           defaults,
 	}
 
 	fieldMapMutex.Lock()
-	structMap[st] = sinfo
+	structMap[structKey{st, opts}] = sinfo
 	fieldMapMutex.Unlock()
 	return sinfo, nil
 }
 
+// hasDefaults returns whether t is a struct type holding fields with
+// default values, directly or within nested structs.
+func hasDefaults(t reflect.Type, opts structOptions) bool {
+	if t.Kind() != reflect.Struct || reflect.PtrTo(t).Implements(unmarshalerType) {
+		return false
+	}
+	sinfo, err := getStructInfo(t, opts)
+	if err != nil {
+		// Reported if a value of the type is ever decoded.
+		return false
+	}
+	return len(sinfo.Defaults) > 0
+}
+
+// isMergeable returns whether values of type t may be merged into
+// a mapping: structs and maps, or slices of them.
+func isMergeable(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	return t.Kind() == reflect.Struct || t.Kind() == reflect.Map
+}
+
 // IsZeroer is used to check whether an object is zero to
 // determine whether it should be omitted when marshaling
 // with the omitempty flag. One notable implementation
@@ -49,6 +49,8 @@ const (
 	yaml_UTF8_ENCODING    // The default UTF-8 encoding.
 	yaml_UTF16LE_ENCODING // The UTF-16-LE encoding with BOM.
 	yaml_UTF16BE_ENCODING // The UTF-16-BE encoding with BOM.
+	yaml_UTF32LE_ENCODING // [Go] The UTF-32-LE encoding, only for input.
+	yaml_UTF32BE_ENCODING // [Go] The UTF-32-BE encoding, only for input.
 )
 
 type yaml_break_t int
@@ -349,6 +351,7 @@ const (
 	// Not in original libyaml.
 	yaml_BINARY_TAG = "tag:yaml.org,2002:binary"
 	yaml_MERGE_TAG  = "tag:yaml.org,2002:merge"
+	yaml_SET_TAG    = "tag:yaml.org,2002:set"
 
 	yaml_DEFAULT_SCALAR_TAG   = yaml_STR_TAG // The default scalar tag is !!str.
 	yaml_DEFAULT_SEQUENCE_TAG = yaml_SEQ_TAG // The default sequence tag is !!seq.
@@ -586,6 +589,8 @@ type yaml_parser_t struct {
 
 	newlines int // The number of line breaks since last non-break/non-blank character
 
+	line_break yaml_break_t // [Go] The style of the first line break in the input.
+
 	raw_buffer     []byte // The raw buffer.
 	raw_buffer_pos int    // The current position of the buffer.
 
@@ -730,6 +735,9 @@ type yaml_emitter_t struct {
 	unicode     bool         // Allow unescaped non-ASCII characters?
 	line_break  yaml_break_t // The preferred line break.
 
+	indentless_sequences bool // Don't indent block sequences which are mapping values?
+	utf8_bom             bool // [Go] Write a BOM with the UTF-8 encoding too?
+
 	state  yaml_emitter_state_t   // The current emitter state.
 	states []yaml_emitter_state_t // The stack of states.
 
@@ -738,6 +746,8 @@ type yaml_emitter_t struct {
 
 	indents []int // The stack of indentation levels.
 
+	sets []bool // [Go] The stack of mappings being !!set?
+
 	tag_directives []yaml_tag_directive_t // The list of tag directives.
 
 	indent int // The current indentation level.